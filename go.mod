@@ -1,3 +1,3 @@
 module github.com/alextomaili/go-collections
 
-go 1.14
+go 1.20
@@ -0,0 +1,219 @@
+package concurrentmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+   ConcurrentCache is ConcurrentMap with hard bound of entries count, it evicts entries by CLOCK policy.
+   Slots of the map are the clock itself: the hand walks slots of actual mapData, referenced entry
+   gets second chance (its flag is cleared), not referenced entry is evicted.
+   Get is still lock-free, it only sets referenced flag of the found entry.
+   Writers evict entries under evictLock when map exceeds the bound, so bound may be exceeded
+   by amount of concurrent writers for a short time.
+   Expiration time is kept by the item, not by the map: map never drops expired entry by itself,
+   so every expired value is removed by the cache, counted as eviction and passed to onEvict.
+   Expired entries are evicted before live ones: every turn of the hand starts by eviction of all expired
+   entries, so cost of the scan is amortized by the turn. Expired entry is also evicted when the hand reaches it,
+   even if it is referenced, and by Get, Put and Del of its key.
+*/
+
+type cacheItem[V any] struct {
+	value      V
+	expiresAt  int64 //unix time in nanoseconds, 0 if item never expires
+	referenced int32 //used by atomic operations
+}
+
+//CacheStats is snapshot of cache counters
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+type ConcurrentCache[K comparable, V any] struct {
+	m          *ConcurrentMap[K, *cacheItem[V]]
+	maxEntries int
+	onEvict    func(key K, value V)
+
+	evictLock sync.Mutex
+	hand      int  //protected by evictLock
+	swept     bool //expired entries are evicted in the current turn of the hand, protected by evictLock
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+//NewConcurrentCache creates cache of maxEntries entries at most, onEvict (if not nil) is called
+//for every evicted entry, e.g. to release evicted value to the pool.
+//Live values replaced by Put and deleted by Del are returned to the caller and are not passed to onEvict,
+//expired values are always evicted
+func NewConcurrentCache[K comparable, V any](hasher Hasher[K], maxEntries int, onEvict func(key K, value V), opts ...Option) *ConcurrentCache[K, V] {
+	if maxEntries <= 0 {
+		panic("maxEntries must be positive")
+	}
+	return &ConcurrentCache[K, V]{
+		m:          NewConcurrentMap[K, *cacheItem[V]](hasher, opts...),
+		maxEntries: maxEntries,
+		onEvict:    onEvict}
+}
+
+//SetClock replaces clock used for expiration of entries, it must be called before the cache is shared between threads
+func (c *ConcurrentCache[K, V]) SetClock(clock Clock) {
+	c.m.SetClock(clock)
+}
+
+//Get returns value of the key and marks entry as recently used, lock-free
+func (c *ConcurrentCache[K, V]) Get(key K) (V, bool) {
+	item := c.m.Get(key)
+	if item != nil && c.expired(item) {
+		if c.m.CompareAndDelete(key, item) {
+			c.evicted(key, item)
+		}
+		item = nil
+	}
+	if item == nil {
+		c.misses.Add(1)
+		var absent V
+		return absent, false
+	}
+	c.hits.Add(1)
+	if atomic.LoadInt32(&item.referenced) == 0 {
+		//don't write shared memory on every hit
+		atomic.StoreInt32(&item.referenced, 1)
+	}
+	return item.value, true
+}
+
+//Put stores value, evicts entries if cache exceeds the bound, returns previous value of the key and true if it was present
+func (c *ConcurrentCache[K, V]) Put(key K, value V) (V, bool) {
+	return c.PutWithTTL(key, value, 0)
+}
+
+//PutWithTTL stores value which expires after ttl, value stored with ttl <= 0 never expires.
+//Replaced expired value is evicted, it is passed to onEvict
+func (c *ConcurrentCache[K, V]) PutWithTTL(key K, value V, ttl time.Duration) (V, bool) {
+	item := &cacheItem[V]{value: value}
+	if ttl > 0 {
+		item.expiresAt = c.m.clock.Now().Add(ttl).UnixNano()
+	}
+	//new entry is not referenced, entry which is never read again is evicted by the first turn of the hand
+	old := c.m.Put(key, item)
+	if c.m.GetCount() > c.maxEntries {
+		c.evict()
+	}
+	if old != nil && c.expired(old) {
+		c.evicted(key, old)
+		old = nil
+	}
+	if old == nil {
+		var absent V
+		return absent, false
+	}
+	return old.value, true
+}
+
+//Del deletes key, returns deleted value and true if key was present, deleted expired value is evicted
+func (c *ConcurrentCache[K, V]) Del(key K) (V, bool) {
+	old := c.m.Del(key)
+	if old != nil && c.expired(old) {
+		c.evicted(key, old)
+		old = nil
+	}
+	if old == nil {
+		var absent V
+		return absent, false
+	}
+	return old.value, true
+}
+
+func (c *ConcurrentCache[K, V]) Len() int {
+	return c.m.GetCount()
+}
+
+func (c *ConcurrentCache[K, V]) Stats() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load()}
+}
+
+func (c *ConcurrentCache[K, V]) expired(item *cacheItem[V]) bool {
+	return isExpiredAt(item.expiresAt, c.m.clock)
+}
+
+//evicted counts eviction of the item which is already deleted from the map and passes it to onEvict
+func (c *ConcurrentCache[K, V]) evicted(key K, item *cacheItem[V]) {
+	c.evictions.Add(1)
+	if c.onEvict != nil {
+		c.onEvict(key, item.value)
+	}
+}
+
+//evict moves the hand until cache fits the bound, two turns of the hand are enough to clear all
+//referenced flags, so it stops after two turns even if evicted entries are inserted again concurrently
+func (c *ConcurrentCache[K, V]) evict() {
+	c.evictLock.Lock()
+	defer c.evictLock.Unlock()
+
+	for steps := 0; c.m.GetCount() > c.maxEntries; steps++ {
+		map_data := (*mapData)(atomic.LoadPointer(&c.m.data)) //volatile read
+		if steps > 2*map_data.capacity {
+			return
+		}
+		if c.hand >= map_data.capacity {
+			c.hand = 0 //start new turn, or map_data is replaced by smaller one
+			c.swept = false
+		}
+		if !c.swept {
+			c.swept = true
+			c.evictExpired(map_data)
+			continue
+		}
+		entryPtr := atomic.LoadPointer(&map_data.data[c.hand])
+		c.hand++
+
+		if entryPtr == nil || entryPtr == movedMarker {
+			continue
+		}
+		e := (*entry[K, *cacheItem[V]])(entryPtr)
+		if e.origin != nil {
+			e = e.origin
+		}
+		if e.deleted {
+			continue
+		}
+		item, _ := e.load()
+		if !c.expired(item) && atomic.LoadInt32(&item.referenced) != 0 {
+			atomic.StoreInt32(&item.referenced, 0) //second chance
+			continue
+		}
+		//expired entry is evicted even if it is referenced
+		if c.m.CompareAndDelete(e.key, item) {
+			c.evicted(e.key, item)
+		}
+	}
+}
+
+//evictExpired evicts all expired entries of map_data, caller holds evictLock
+func (c *ConcurrentCache[K, V]) evictExpired(map_data *mapData) {
+	for i := 0; i < map_data.capacity; i++ {
+		entryPtr := atomic.LoadPointer(&map_data.data[i])
+		if entryPtr == nil || entryPtr == movedMarker {
+			continue
+		}
+		e := (*entry[K, *cacheItem[V]])(entryPtr)
+		if e.origin != nil {
+			e = e.origin
+		}
+		if e.deleted {
+			continue
+		}
+		if item, _ := e.load(); c.expired(item) && c.m.CompareAndDelete(e.key, item) {
+			c.evicted(e.key, item)
+		}
+	}
+}
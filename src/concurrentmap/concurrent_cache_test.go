@@ -0,0 +1,201 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/alextomaili/go-collections/src/lockfreepool"
+)
+
+const (
+	cache_test_max_entries = 256
+	cache_test_keys        = 4096
+)
+
+func TestConcurrentCacheBound(t *testing.T) {
+	evicted := make(map[string]int)
+	c := NewConcurrentCache[string, int](NewStrHasher(), cache_test_max_entries, func(key string, value int) {
+		if key != "key#"+strconv.Itoa(value) {
+			t.Errorf("unexpected evicted value %d for key %s", value, key)
+		}
+		evicted[key]++
+	})
+
+	for i := 0; i < cache_test_keys; i++ {
+		c.Put("key#"+strconv.Itoa(i), i)
+		if c.Len() > cache_test_max_entries {
+			t.Fatalf("cache exceeds the bound: %d", c.Len())
+		}
+	}
+
+	if len(evicted) != cache_test_keys-cache_test_max_entries {
+		t.Errorf("expected %d evicted keys, but found %d", cache_test_keys-cache_test_max_entries, len(evicted))
+	}
+	present := 0
+	for i := 0; i < cache_test_keys; i++ {
+		key := "key#" + strconv.Itoa(i)
+		_, ok := c.Get(key)
+		if ok {
+			present++
+		}
+		if ok == (evicted[key] == 1) {
+			t.Errorf("key %s must be either present or evicted once, evicted %d times", key, evicted[key])
+		}
+	}
+	if present != cache_test_max_entries {
+		t.Errorf("expected %d present keys, but found %d", cache_test_max_entries, present)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != cache_test_max_entries || stats.Misses != cache_test_keys-cache_test_max_entries {
+		t.Errorf("unexpected hits/misses: %+v", stats)
+	}
+	if stats.Evictions != cache_test_keys-cache_test_max_entries {
+		t.Errorf("unexpected evictions: %+v", stats)
+	}
+
+	if v, ok := c.Put("key#0", -1); ok {
+		t.Errorf("evicted key must be absent, but found %d", v)
+	}
+	if v, ok := c.Put("key#0", 0); !ok || v != -1 {
+		t.Errorf("Put must return previous value, but found %d, %v", v, ok)
+	}
+	if v, ok := c.Del("key#0"); !ok || v != 0 {
+		t.Errorf("Del must return deleted value, but found %d, %v", v, ok)
+	}
+}
+
+//recently used keys must get second chance and survive eviction of cold keys
+func TestConcurrentCacheKeepsHotKeys(t *testing.T) {
+	c := NewConcurrentCache[int, int](IntHasher{}, cache_test_max_entries, nil)
+	hot := cache_test_max_entries / 4
+	for i := 0; i < hot; i++ {
+		c.Put(i, i)
+	}
+
+	for i := hot; i < cache_test_keys; i++ {
+		c.Put(i, i)
+		c.Get(i % hot)
+	}
+
+	for i := 0; i < hot; i++ {
+		if v, ok := c.Get(i); !ok || v != i {
+			t.Errorf("hot key %d must not be evicted", i)
+		}
+	}
+}
+
+//evicted values are released to the pool and taken again, no value may be used by two present keys
+func TestConcurrentCacheReleaseToPool(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) < 8 {
+		t.Skip("lockfreepool uses 64-bit atomics of not aligned fields, it works on 64-bit platforms only")
+	}
+	pool := lockfreepool.NewFixedSizeRingPool(cache_test_keys)
+	released := int64(0)
+	c := NewConcurrentCache[int, *[]byte](IntHasher{}, cache_test_max_entries, func(key int, value *[]byte) {
+		if int((*value)[0]) != key%256 {
+			t.Errorf("evicted buffer doesn't belong to key %d", key)
+		}
+		atomic.AddInt64(&released, 1)
+		pool.Put(value)
+	})
+	threadCount := runtime.NumCPU() + 1
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < cache_test_keys; i++ {
+				key := i*threadCount + th
+				buffer, _ := pool.Get().(*[]byte)
+				if buffer == nil {
+					b := make([]byte, 1)
+					buffer = &b
+				}
+				(*buffer)[0] = byte(key % 256)
+				c.Put(key, buffer)
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	if c.Len() > cache_test_max_entries {
+		t.Errorf("cache exceeds the bound: %d", c.Len())
+	}
+	if int(released)+c.Len() != cache_test_keys*threadCount {
+		t.Errorf("every value must be present or released, released %d, present %d", released, c.Len())
+	}
+	buffers := make(map[*[]byte]int)
+	for key := 0; key < cache_test_keys*threadCount; key++ {
+		if v, ok := c.Get(key); ok {
+			if int((*v)[0]) != key%256 {
+				t.Errorf("buffer of key %d is reused by other key", key)
+			}
+			buffers[v]++
+		}
+	}
+	if len(buffers) != c.Len() {
+		t.Errorf("present keys must not share buffers, %d buffers for %d keys", len(buffers), c.Len())
+	}
+	if c.Stats().Evictions != released {
+		t.Errorf("evictions %d must be equal to released values %d", c.Stats().Evictions, released)
+	}
+}
+
+//expired entries are evicted before live ones and every expired value is passed to onEvict
+func TestConcurrentCacheEvictsExpired(t *testing.T) {
+	clock := newFakeClock()
+	evicted := make(map[int]int)
+	c := NewConcurrentCache[int, int](IntHasher{}, 10, func(key int, value int) {
+		evicted[key] = value
+	})
+	c.SetClock(clock)
+
+	for i := 0; i < 10; i++ {
+		c.PutWithTTL(i, i, time.Second)
+	}
+	clock.advance(2 * time.Second)
+	for i := 100; i < 110; i++ {
+		c.Put(i, i)
+	}
+
+	for i := 100; i < 110; i++ {
+		if v, ok := c.Get(i); !ok || v != i {
+			t.Errorf("fresh key %d must be present", i)
+		}
+	}
+	if c.Len() != 10 || len(evicted) != 10 || c.Stats().Evictions != 10 {
+		t.Errorf("10 expired keys must be evicted, len %d, evicted %v, stats %+v", c.Len(), evicted, c.Stats())
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := evicted[i]; !ok || v != i {
+			t.Errorf("expired key %d must be passed to onEvict", i)
+		}
+	}
+
+	//expired value found by Get, Put or Del is evicted too
+	c.PutWithTTL(200, 200, time.Second)
+	c.PutWithTTL(201, 201, time.Second)
+	c.PutWithTTL(202, 202, time.Second)
+	clock.advance(2 * time.Second)
+	if _, ok := c.Get(200); ok {
+		t.Errorf("expired key must be absent")
+	}
+	if _, ok := c.Put(201, -1); ok {
+		t.Errorf("expired key must be absent")
+	}
+	if _, ok := c.Del(202); ok {
+		t.Errorf("expired key must be absent")
+	}
+	for _, key := range []int{200, 201, 202} {
+		if evicted[key] != key {
+			t.Errorf("expired key %d must be passed to onEvict", key)
+		}
+	}
+}
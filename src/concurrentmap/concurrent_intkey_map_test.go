@@ -335,3 +335,133 @@ func TestStdMapTimeProf(t *testing.T)  {
 	}
 	fmt.Printf("holder -> %d, count -> %d\n", holder, cnt);
 }
+
+// --- sync.Map compatible implementations: SyncMap adapter, sync.Map, map protected by RWMutex
+
+const matrix_bench_keys = 1 << 16
+
+type tstRwLockMap struct {
+	mu sync.RWMutex
+	m  map[any]any
+}
+
+func (t *tstRwLockMap) Load(key any) (any, bool) {
+	t.mu.RLock()
+	v, ok := t.m[key]
+	t.mu.RUnlock()
+	return v, ok
+}
+
+func (t *tstRwLockMap) Store(key, value any) {
+	t.mu.Lock()
+	t.m[key] = value
+	t.mu.Unlock()
+}
+
+func (t *tstRwLockMap) LoadOrStore(key, value any) (any, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if v, ok := t.m[key]; ok {
+		return v, true
+	}
+	t.m[key] = value
+	return value, false
+}
+
+func (t *tstRwLockMap) LoadAndDelete(key any) (any, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.m[key]
+	delete(t.m, key)
+	return v, ok
+}
+
+func (t *tstRwLockMap) Delete(key any) {
+	t.mu.Lock()
+	delete(t.m, key)
+	t.mu.Unlock()
+}
+
+func (t *tstRwLockMap) Range(f func(key, value any) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for k, v := range t.m {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+//BenchmarkMapMatrix runs every workload on every implementation, with disjoint and overlapping keys
+func BenchmarkMapMatrix(b *testing.B) {
+	impls := []struct {
+		name  string
+		build func() Map
+	}{
+		{"SyncMapAdapter", func() Map { return &SyncMap{} }},
+		{"SyncMap", func() Map { return &sync.Map{} }},
+		{"RwLockMap", func() Map { return &tstRwLockMap{m: make(map[any]any)} }},
+	}
+	workloads := []struct {
+		name        string
+		writesPer16 int
+	}{
+		{"ReadHeavy", 1},
+		{"Mixed", 4},
+		{"WriteHeavy", 16},
+	}
+
+	for _, impl := range impls {
+		for _, workload := range workloads {
+			for _, disjoint := range []bool{true, false} {
+				keys := "Overlapping"
+				if disjoint {
+					keys = "Disjoint"
+				}
+				b.Run(impl.name+"/"+workload.name+"/"+keys, func(b *testing.B) {
+					benchmarkMapWorkload(b, impl.build(), workload.writesPer16, disjoint)
+				})
+			}
+		}
+	}
+}
+
+//benchmarkMapWorkload: writesPer16 of every 16 operations are writes, half of them Store,
+//half LoadOrStore after LoadAndDelete. Disjoint keys: every goroutine has own range of keys
+func benchmarkMapWorkload(b *testing.B, m Map, writesPer16 int, disjoint bool) {
+	for i := 0; i < matrix_bench_keys; i++ {
+		m.Store(i, i)
+	}
+	threadCount := runtime.NumCPU()
+	rangeSize := matrix_bench_keys
+	if disjoint {
+		rangeSize = matrix_bench_keys / threadCount
+	}
+	var threadId int32
+
+	b.ResetTimer()
+	b.SetParallelism(1)
+	b.RunParallel(func(pb *testing.PB) {
+		th := int(atomic.AddInt32(&threadId, 1)) - 1
+		base := 0
+		if disjoint {
+			base = (th % threadCount) * rangeSize
+		}
+		rnd := rand.New(rand.NewSource(int64(th)))
+		for pb.Next() {
+			key := base + rnd.Intn(rangeSize)
+			op := rnd.Intn(32)
+			switch {
+			case op < writesPer16:
+				m.Store(key, op)
+			case op < 2*writesPer16:
+				m.LoadAndDelete(key)
+				m.LoadOrStore(key, op)
+			default:
+				if v, ok := m.Load(key); ok {
+					holder += v.(int)
+				}
+			}
+		}
+	})
+}
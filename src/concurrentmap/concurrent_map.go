@@ -1,10 +1,12 @@
 package concurrentmap
 
 import (
+	"hash/maphash"
+	"math/bits"
+	"runtime"
 	"unsafe"
 	"sync"
 	"sync/atomic"
-	"fmt"
 )
 
 const (
@@ -17,12 +19,17 @@ const (
 	//good value from java framework
 	default_load_factor = float32(0.75)
 
-	//used to calculate hash from key
-	hashShift = 16
+	//used to calculate slot from hash code of the key, 2^64 / golden ratio
+	fibonacciMultiplier uint64 = 0x9E3779B97F4A7C15
 
 	decreaseCapacityRate = 2
 
-	//key types
+	//compaction starts when 1/tombstonesRate of slots are occupied by deleted entries
+	tombstonesRate = 4
+)
+
+//Deprecated: key kinds of the untyped map, key type is the type parameter of ConcurrentMap now
+const (
 	KT_INT = 0
 	KT_STRING = 1
 )
@@ -30,74 +37,138 @@ const (
 
 type Data interface{}
 
-type entry struct {
-	intKey  int
-	strKey  string
-	value   Data
-	deleted bool
+//Hasher calculates hash code of the key, equal keys must have equal hash codes
+type Hasher[K comparable] interface {
+	Hash(key K) uint64
 }
 
-func buildNewEntry(keyKind byte, intKey int, strKey *string, value Data, deleted bool) *entry  {
-	newEntry := &entry {
+type entry[K comparable, V any] struct {
+	hash      uint64
+	seq       uint64       //used by atomic operations, see concurrent_map_inplace.go
+	expiresAt int64        //unix time in nanoseconds, 0 if entry never expires, see concurrent_map_expiry.go
+	key       K
+	value     V            //live entry may be written in place, read it by load()
+	origin    *entry[K, V] //not nil if entry is moved to the next mapData, actual content is in origin
+	deleted   bool
+	words     uint8        //not 0 if value is written in place, amount of pointer words of value
+}
+
+func buildNewEntry[K comparable, V any](h uint64, key K, value V, deleted bool) *entry[K, V]  {
+	return &entry[K, V] {
+		hash: h,
+		key: key,
 		value: value,
 		deleted: deleted }
+}
 
-	switch keyKind {
-	case KT_INT:
-		newEntry.intKey = intKey;
-	case KT_STRING:
-		newEntry.intKey = intKey;
-		newEntry.strKey = *strKey;
-	default:
-		panic(fmt.Errorf("Unsuported keyKind: %d", keyKind))
-	}
-
-	return newEntry
+//matches checks the key of the entry, hash is compared first, it is cheaper than compare of the keys
+func (e *entry[K, V]) matches(h uint64, key K) bool {
+	return e.hash == h && e.key == key
 }
 
 type mapData struct {
 	loadFactor     float32
 	threshold      int
 	capacity       int
-	estimatedCount int32            //used by atomic operations
+	tombstones     int32            //used by atomic operations
+	used           atomic.Int64     //not empty slots and slots reserved for insert, atomic.Int64 is aligned on 32-bit platforms too
 	data           []unsafe.Pointer // data[0]->(*entry), data[1]->(*entry)
+
+	//incremental migration to the next mapData, see concurrent_map_transfer.go
+	next          unsafe.Pointer // -> mapData
+	prev          unsafe.Pointer // -> mapData, not nil while this mapData is filled by migration
+	transferIndex atomic.Int64
+	transferred   atomic.Int64
 }
 
-type CMap struct {
-	keyKind byte
-	data         unsafe.Pointer // -> mapData
-	mutatorsLock sync.RWMutex
+//ConcurrentMap is lock-free for readers, except reader of interface value (like Data) or value with ttl which waits
+//for concurrent in-place writer of the same key, see concurrent_map_inplace.go. Writers are synchronized by CAS per slot,
+//mutatorsLock is read-locked by every writer and write-locked by Clear, Snapshot (Clone, WriteTo) and Validate,
+//map grows and drops deleted entries by incremental migration, see concurrent_map_transfer.go
+type ConcurrentMap[K comparable, V any] struct {
+	hasher         Hasher[K]
+	options        mapOptions
+	clock          Clock
+	data           unsafe.Pointer // -> mapData
+	count          stripedCounter //live entries
+	rehashes       atomic.Int64   //started migrations
+	valueWords     uint8          //see valueWords()
+	mutatorsLock   sync.RWMutex
 }
 
+//probeResult reports how probe of the slots for the key is finished
+type probeResult byte
+
+const (
+	probeDone  probeResult = iota
+	probeFull                     //key is absent and there is no free slot to insert it
+	probeMoved                    //slot of the key is moved to the next mapData, operation must be restarted
+)
+
 func calc_threshold(capacity int, load_factor float32) int {
 	return int(float32(capacity) * load_factor);
 }
 
-func hash(h int, tLen int) int {
-	//from java.util.HashMap, java 1.8
-	h = h ^ (h >> hashShift)
-	//length must be a non-zero power of 2, faster than index % tableLen
-	return int(h) & (tLen - 1)
+//hash returns slot of the hash code by fibonacci hashing: high bits of the product depend on all bits of the hash code,
+//so sequential or strided keys (ids, keys with equal low bits) are spread over the table instead of clusters.
+//Robin Hood probing is not used: it moves entries between slots, but lock-free readers and iterators rely on
+//entries which are never moved inside of one mapData
+func hash(h uint64, tLen int) int {
+	//length must be a non-zero power of 2, top log2(length) bits of the product are the slot
+	return int((h * fibonacciMultiplier) >> (64 - bits.TrailingZeros(uint(tLen))))
 }
 
-func (m *CMap) kGet(intKey  int, strKey  *string) Data {
-	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
-	map_capacity := map_data.capacity
-	isIntKey := m.keyKind == KT_INT
-	index := hash(intKey, map_capacity) // compute hashcode
+func newMapData(capacity int, loadFactor float32) *mapData {
+	return &mapData{
+		loadFactor: loadFactor,
+		threshold: calc_threshold(capacity, loadFactor),
+		capacity: capacity,
+		data: make([]unsafe.Pointer, capacity)}
+}
+
+func NewConcurrentMap[K comparable, V any](hasher Hasher[K], opts ...Option) *ConcurrentMap[K, V] {
+	m := &ConcurrentMap[K, V]{}
+	m.init(hasher, opts)
+	return m
+}
 
+func (m *ConcurrentMap[K, V]) init(hasher Hasher[K], opts []Option) {
+	m.hasher = hasher
+	m.options = buildOptions(opts)
+	m.clock = m.options.clock
+	m.count = newStripedCounter()
+	m.valueWords = valueWords[V]()
+	atomic.StorePointer(&m.data, unsafe.Pointer(newMapData(m.options.initialCapacity, m.options.loadFactor)))
+}
+
+//find returns entry of the key (it may be deleted entry) or nil if there is no entry of the key,
+//moved slots are looked up in the next mapData
+func find[K comparable, V any](map_data *mapData, h uint64, key K) *entry[K, V] {
+	map_capacity := map_data.capacity
+	index := hash(h, map_capacity) // compute hashcode
 
 	for i := 0; i < map_capacity; i++ {
 		//volatile red, other thread may write in same time
 		entryPtr := atomic.LoadPointer(&map_data.data[index])
-		oldEntry := (*entry)(entryPtr)
 
-		if oldEntry == nil {
+		if entryPtr == nil {
 			return nil
 		}
+		if entryPtr == movedMarker {
+			//end of probe chain is moved, key may be inserted into the next mapData only
+			return find[K, V](nextData(map_data), h, key)
+		}
 
-		if !oldEntry.deleted && oldEntry.intKey == intKey && (isIntKey || oldEntry.strKey == *strKey) {
-			return oldEntry.value
+		oldEntry := (*entry[K, V])(entryPtr)
+		if oldEntry.matches(h, key) {
+			if oldEntry.origin == nil {
+				return oldEntry
+			}
+			//entry is moved, next mapData has actual version if entry is already copied there
+			if actual := find[K, V](nextData(map_data), h, key); actual != nil {
+				return actual
+			}
+			return oldEntry.origin
 		}
 
 		//next probe
@@ -109,30 +180,94 @@ func (m *CMap) kGet(intKey  int, strKey  *string) Data {
 	return nil
 }
 
-func (m *CMap) kDel(intKey  int, strKey  *string) Data {
+//Get returns value of the key, zero value if key is absent, see Load to tell absent key from stored zero value
+func (m *ConcurrentMap[K, V]) Get(key K) V {
+	value, _ := m.load(m.hasher.Hash(key), key)
+	return value
+}
+
+//Load returns value of the key and true, or zero value and false if key is absent, like sync.Map Load
+func (m *ConcurrentMap[K, V]) Load(key K) (V, bool) {
+	return m.load(m.hasher.Hash(key), key)
+}
+
+func (m *ConcurrentMap[K, V]) load(h uint64, key K) (V, bool) {
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	if e := find[K, V](map_data, h, key); e != nil && !e.deleted {
+		value, expiresAt := e.load()
+		if isExpiredAt(expiresAt, m.clock) {
+			m.removeExpired(h, key)
+			var absent V
+			return absent, false
+		}
+		return value, true
+	}
+	var absent V
+	return absent, false
+}
+
+//Del deletes the key and returns its value, zero value if key is absent, see LoadAndDelete
+func (m *ConcurrentMap[K, V]) Del(key K) V {
+	value, _ := m.remove(m.hasher.Hash(key), key)
+	return value
+}
+
+//LoadAndDelete deletes the key and returns its value and true, or zero value and false if key is absent,
+//like sync.Map LoadAndDelete
+func (m *ConcurrentMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	return m.remove(m.hasher.Hash(key), key)
+}
+
+func (m *ConcurrentMap[K, V]) remove(h uint64, key K) (V, bool) {
 	m.mutatorsLock.RLock()
 	defer m.mutatorsLock.RUnlock()
 
-	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	for {
+		map_data := m.writableData(h, key)
+		value, found, result := m.del(map_data, h, key)
+		if result != probeMoved {
+			return value, found
+		}
+	}
+}
+
+func (m *ConcurrentMap[K, V]) del(map_data *mapData, h uint64, key K) (V, bool, probeResult) {
+	var absent V
 	map_capacity := map_data.capacity
-	isIntKey := m.keyKind == KT_INT
-	index := hash(intKey, map_capacity) // compute hashcode
+	index := hash(h, map_capacity) // compute hashcode
 
 	for i := 0; i < map_capacity; i++ {
 		//volatile red, other thread may write in same time
 		entryPtr := atomic.LoadPointer(&map_data.data[index])
-		oldEntry := (*entry)(entryPtr)
 
-		if oldEntry == nil {
-			return nil
+		if entryPtr == nil {
+			return absent, false, probeDone
+		}
+		if entryPtr == movedMarker {
+			return absent, false, probeMoved
 		}
 
-		if !oldEntry.deleted && oldEntry.intKey == intKey && (isIntKey || oldEntry.strKey == *strKey) {
-			newEntry := buildNewEntry(m.keyKind, intKey, strKey, nil, true)
-			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, unsafe.Pointer(newEntry)) {
-				atomic.AddInt32(&map_data.estimatedCount, -1)
+		oldEntry := (*entry[K, V])(entryPtr)
+		if oldEntry.matches(h, key) {
+			if oldEntry.origin != nil {
+				return absent, false, probeMoved
+			}
+			if oldEntry.deleted {
+				return absent, false, probeDone
 			}
-			return oldEntry.value
+			newEntry := buildNewEntry(h, key, absent, true)
+			if !replaceEntry(map_data, index, oldEntry, newEntry) {
+				i-- //slot was changed by other thread, look at it again
+				continue
+			}
+			m.count.add(h, -1)
+			m.ensureCompacted(map_data, atomic.AddInt32(&map_data.tombstones, 1))
+			//entry is retired, its value is never written again
+			value, expiresAt := oldEntry.load()
+			if isExpiredAt(expiresAt, m.clock) {
+				return absent, false, probeDone
+			}
+			return value, true, probeDone
 		}
 
 		//next probe
@@ -141,143 +276,218 @@ func (m *CMap) kDel(intKey  int, strKey  *string) Data {
 			index = 0
 		}
 	}
-	return nil
+	return absent, false, probeDone
 }
 
-func (m *CMap) kPut(intKey  int, strKey  *string, value Data) Data {
-	//prepare new entry
-	newEntry := buildNewEntry(m.keyKind, intKey, strKey, value, false)
-	//W-LOCK (possible): if grow is really needed - write lock used inside to protect it
-	m.ensureCapacity()
+func (m *ConcurrentMap[K, V]) Put(key K, value V) V {
+	return m.store(m.hasher.Hash(key), key, value, 0)
+}
 
-	//R-LOCK: read lock used inside, try concurrent insert
-	data, upsert := m.put(newEntry)
-	if upsert {
-		map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
-		atomic.AddInt32(&map_data.estimatedCount, 1)
-		return data
+//PutAll stores all entries, map grows once for all of them before the first insert
+func (m *ConcurrentMap[K, V]) PutAll(entries map[K]V) {
+	m.reserve(len(entries))
+	for key, value := range entries {
+		m.Put(key, value)
 	}
+}
 
-	//W-LOCK: exclusive insert, we can't insert anyway, try grow again and insert with write lock
-	m.mutatorsLock.Lock()
-	defer m.mutatorsLock.Unlock()
+//reserve grows the map to have free slots for amount of entries, it helps migration till the end
+func (m *ConcurrentMap[K, V]) reserve(entries int) {
+	m.mutatorsLock.RLock()
+	defer m.mutatorsLock.RUnlock()
 
-	new_map_data := m.rehash()
-	d, done := _put(new_map_data, newEntry, m.keyKind)
-	if !done {
-		panic("Can't insert entry, no capacity after grow")
+	for {
+		m.finishTransfers()
+		map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+		occupied := int(map_data.used.Load()) + entries
+		if occupied <= map_data.threshold || map_data.capacity >= m.options.maxCapacity {
+			return
+		}
+		new_capacity := capacityFor(occupied, map_data.loadFactor)
+		if new_capacity > m.options.maxCapacity {
+			new_capacity = m.options.maxCapacity
+		}
+		if atomic.LoadPointer(&m.data) == unsafe.Pointer(map_data) && startTransfer(map_data, new_capacity) {
+			m.rehashes.Add(1)
+		}
 	}
-	atomic.StorePointer(&m.data, unsafe.Pointer(new_map_data))
+}
+
+//store puts value of the key, returns previous not expired value
+func (m *ConcurrentMap[K, V]) store(h uint64, key K, value V, expiresAt int64) V {
+	//start migration to the bigger mapData if grow is really needed
+	m.ensureCapacity()
 
-	return d
+	//R-LOCK: read lock used inside, try concurrent insert
+	prevValue, prevExpiresAt, found := m.put(h, key, value, expiresAt)
+	if !found || isExpiredAt(prevExpiresAt, m.clock) {
+		var absent V
+		return absent
+	}
+	return prevValue
 }
 
-func (m *CMap) GetCount() int {
-	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
-	count := atomic.LoadInt32(&map_data.estimatedCount)
+//Len returns amount of entries, it is exact if there are no concurrent updates
+func (m *ConcurrentMap[K, V]) Len() int {
+	count := m.count.sum()
 	if count < 0 {
+		//delete is counted before concurrent insert of the same entry
 		return 0
-	} else {
-		return int(count)
 	}
+	return int(count)
 }
 
-func (m *CMap) ensureCapacity() {
-	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
-	estimatedCount := atomic.LoadInt32(&map_data.estimatedCount)
-	if int(estimatedCount) + 1 <= map_data.threshold {
-		return //already have enough capacity
-	}
-
-	m.mutatorsLock.Lock()
-	defer m.mutatorsLock.Unlock()
-
-	new_map_data := m.rehash()
-	atomic.StorePointer(&m.data, unsafe.Pointer(new_map_data))
+//GetCount returns amount of entries, see Len
+func (m *ConcurrentMap[K, V]) GetCount() int {
+	return m.Len()
 }
 
-//always calls under W-LOCK
-func (m *CMap) rehash() *mapData {
-	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+//ensureCapacity starts migration to the bigger mapData if there is no free space for one more entry,
+//if migration is in progress and next mapData is not enough too - helps to finish it before.
+//Migration is started under the read lock like any other write, so Validate never sees it half-started
+func (m *ConcurrentMap[K, V]) ensureCapacity() {
+	m.mutatorsLock.RLock()
+	defer m.mutatorsLock.RUnlock()
 
-	//check, may be we will have necessary amount of free space without grow size ----------------
-	occupied := 0
-	for i := 0; i < map_data.capacity; i++ {
-		//we are om W-LOCK and owns map_data after volatile read here, we can read directly
-		oldEntry := (*entry)(map_data.data[i])
-		if oldEntry != nil && !oldEntry.deleted {
-			occupied++
+	for {
+		map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+		next := nextData(map_data)
+		if next == nil {
+			//deleted entries occupy slots too, but compaction is enough to free them
+			occupied := int(map_data.used.Load()) + 1
+			if !m.ensureCompacted(map_data, atomic.LoadInt32(&map_data.tombstones)) &&
+				occupied > map_data.threshold && map_data.capacity < m.options.maxCapacity {
+				m.migrate(map_data, occupied)
+			}
+			return
+		}
+		if int(next.used.Load()) + 1 <= next.threshold {
+			return //migration is in progress, new entries are inserted into the next mapData
+		}
+
+		//next mapData is too small, it will be migrated too
+		if !m.helpTransfer(map_data, next) {
+			runtime.Gosched() //all chunks are taken, wait for other threads
 		}
 	}
-	occupied++
+}
+
+//newCapacity chooses capacity of the table for required amount of occupied slots:
+//grow if there is no necessary free space, decrease if there are too many free slots
+func (m *ConcurrentMap[K, V]) newCapacity(map_data *mapData, occupied int) int {
 	new_capacity := map_data.capacity
 	if occupied > map_data.threshold {
 		//ok we don't have necessary free space, grow
 		new_capacity = map_data.capacity << 1
-		if new_capacity > max_length {
-			panic("no more capacity")
+		if required := capacityFor(occupied, map_data.loadFactor); required > new_capacity {
+			new_capacity = required
+		}
+		if new_capacity > m.options.maxCapacity {
+			if occupied > map_data.capacity {
+				panic("no more capacity")
+			}
+			new_capacity = map_data.capacity //fill slots over the load factor
 		}
-	} else if occupied < int(map_data.threshold / decreaseCapacityRate) {
+	} else if m.options.shrinkRate > 0 && occupied < int(map_data.threshold / m.options.shrinkRate) {
 		//its time to decrease capacity, we have too many deleted items
-		new_capacity = roundToMinimalPowerOf2(int(float32(occupied) / map_data.loadFactor))
-	}
-	// -------------------------------------------------------------------------------------------
-
-
-	new_map_data := &mapData{
-		loadFactor: default_load_factor,
-		threshold: calc_threshold(new_capacity, default_load_factor),
-		capacity: new_capacity,
-		estimatedCount: 0,
-		data: make([]unsafe.Pointer, new_capacity)}
-
-	for i := 0; i < map_data.capacity; i++ {
-		//we are om W-LOCK and owns map_data after volatile read here, we can read directly
-		oldEntry := (*entry)(map_data.data[i])
-		if oldEntry != nil && !oldEntry.deleted {
-			_put(new_map_data, oldEntry, m.keyKind)
-			new_map_data.estimatedCount++
+		new_capacity = capacityFor(occupied, map_data.loadFactor)
+		if new_capacity < m.options.initialCapacity {
+			new_capacity = m.options.initialCapacity
 		}
 	}
-
-	return new_map_data
+	return new_capacity
 }
 
-func (m *CMap) put(newEntry *entry) (Data, bool) {
+func (m *ConcurrentMap[K, V]) put(h uint64, key K, value V, expiresAt int64) (V, int64, bool) {
 	m.mutatorsLock.RLock()
 	defer m.mutatorsLock.RUnlock()
 
-	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
-	return _put(map_data, newEntry, m.keyKind)
+	var newEntry *entry[K, V] //built once, only if the key has no live entry to write in place
+	for {
+		map_data := m.writableData(h, key)
+		prevValue, prevExpiresAt, found, result := m._put(map_data, h, key, value, expiresAt, &newEntry)
+		switch result {
+		case probeDone:
+			if !found {
+				m.count.add(h, 1) //under the lock, so Validate sees counter matching the slots
+			}
+			return prevValue, prevExpiresAt, found
+		case probeFull:
+			//there is no free slot, migrate to the new mapData and try again
+			m.migrateFull(map_data)
+		}
+	}
 }
 
-func _put(map_data *mapData, newEntry *entry, keyKind byte) (Data, bool) {
+//_put stores value into the slot of the key, returns previous value and expiration time of the live entry,
+//found is false if key was absent or deleted. Live entry is written in place if it is possible, otherwise
+//newEntry is built and stored by CAS
+func (m *ConcurrentMap[K, V]) _put(map_data *mapData, h uint64, key K, value V, expiresAt int64,
+	newEntry **entry[K, V]) (prevValue V, prevExpiresAt int64, found bool, result probeResult) {
 	map_capacity := map_data.capacity
-	isIntKey := keyKind == KT_INT
-	index := hash(newEntry.intKey, map_capacity) // compute hashcode
+	index := hash(h, map_capacity) // compute hashcode
+	reserved := false
+	buildEntry := func() unsafe.Pointer {
+		if *newEntry == nil {
+			*newEntry = m.buildLiveEntry(h, key, value, expiresAt)
+		}
+		return unsafe.Pointer(*newEntry)
+	}
 
 	for i := 0; i < map_capacity; i++ {
 		//try store if not exist
 		entryPtr := atomic.LoadPointer(&map_data.data[index])
-		oldEntry := (*entry)(entryPtr)
-		if oldEntry == nil {
-			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, unsafe.Pointer(newEntry)) {
-				return nil, true
+		if entryPtr == nil {
+			if !reserved {
+				if result := reserveSlot(map_data); result != probeDone {
+					return prevValue, 0, false, result
+				}
+				reserved = true
+			}
+			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, buildEntry()) {
+				return prevValue, 0, false, probeDone
 			}
+			i-- //slot was taken by other thread, may be by our key, look at it again
+			continue
+		}
+		if entryPtr == movedMarker {
+			releaseSlot(map_data, reserved)
+			return prevValue, 0, false, probeMoved
 		}
 
 		//ok, slot is occupied may be this is our value, in this case we must write here,
 		//if value is deleted - ok it will be actual again
-		entryPtr = atomic.LoadPointer(&map_data.data[index])
-		oldEntry = (*entry)(entryPtr)
+		oldEntry := (*entry[K, V])(entryPtr)
+		if oldEntry.matches(h, key) {
+			if oldEntry.origin != nil {
+				releaseSlot(map_data, reserved)
+				return prevValue, 0, false, probeMoved
+			}
+			if oldEntry.words != 0 && !oldEntry.deleted {
+				//write in place, entry is locked, so it can't be replaced in the slot concurrently
+				if !oldEntry.lock() {
+					i-- //entry is retired, slot is changed by other thread, look at it again
+					continue
+				}
+				prevValue, prevExpiresAt = oldEntry.value, oldEntry.expiresAt
+				oldEntry.store(value, expiresAt)
+				oldEntry.unlock()
+				releaseSlot(map_data, reserved)
+				return prevValue, prevExpiresAt, true, probeDone
+			}
 
-		if  oldEntry.intKey == newEntry.intKey && (isIntKey || oldEntry.strKey == newEntry.strKey) {
 			//we must override this value, because this is our key
-			atomic.StorePointer(&map_data.data[index], unsafe.Pointer(newEntry))
+			//CAS, not store: concurrent compute operations must observe every version of the slot
+			if !atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, buildEntry()) {
+				i-- //slot was changed by other thread, it is still our key, try again
+				continue
+			}
+			releaseSlot(map_data, reserved)
 			if oldEntry.deleted {
-				return nil, true
+				atomic.AddInt32(&map_data.tombstones, -1)
+				return prevValue, 0, false, probeDone
 			} else {
-				return oldEntry.value, true
+				return oldEntry.value, oldEntry.expiresAt, true, probeDone
 			}
 
 		}
@@ -288,7 +498,8 @@ func _put(map_data *mapData, newEntry *entry, keyKind byte) (Data, bool) {
 			index = 0
 		}
 	}
-	return nil, false
+	releaseSlot(map_data, reserved)
+	return prevValue, 0, false, probeFull
 }
 
 func roundToMinimalPowerOf2(capacity int) int {
@@ -300,92 +511,79 @@ func roundToMinimalPowerOf2(capacity int) int {
 }
 
 //--------------------------------------------------------------------------------------
-// map with integer key
+// common base of maps with integer and string keys
 //--------------------------------------------------------------------------------------
-type CIntKeyMap struct {
-	CMap
+//CMap is the set of methods which don't depend on the key type, it is implemented by CIntKeyMap and CStrKeyMap.
+//CMap was the untyped base struct of both maps, it is an interface now: m.CMap.GetCount() still compiles,
+//code which took *CMap must take CMap
+type CMap interface {
+	GetCount() int
+	Len() int
+	Clear()
+	Sweep() int
+	Stats() MapStats
+	Validate() error
 }
 
-//empty strKey
-var emptyStringKey string = ""
+var (
+	_ CMap = (*CIntKeyMap)(nil)
+	_ CMap = (*CStrKeyMap)(nil)
+)
 
-func NewCIntKeyMap() *CIntKeyMap {
-	map_data := &mapData{
-		loadFactor: default_load_factor,
-		threshold: calc_threshold(initial_length, default_load_factor),
-		capacity: initial_length,
-		estimatedCount: 0,
-		data: make([]unsafe.Pointer, initial_length)}
-	m := CIntKeyMap{CMap{keyKind: KT_INT, mutatorsLock: sync.RWMutex{}}}
-	atomic.StorePointer(&m.data, unsafe.Pointer(map_data))
-	return &m
-}
+//--------------------------------------------------------------------------------------
+// map with integer key
+//--------------------------------------------------------------------------------------
+//IntHasher keeps the key as is, slot is chosen by fibonacci hashing, see hash().
+//Int is 32 bit on 32-bit platforms, 64-bit ids must be stored in CInt64KeyMap or CUint64KeyMap
+type IntHasher struct{}
 
-func (m *CIntKeyMap) Get(key int) Data {
-	return m.kGet(key, &emptyStringKey);
+func (IntHasher) Hash(key int) uint64 {
+	return uint64(key)
 }
 
-func (m *CIntKeyMap) Del(key int) Data {
-	return m.kDel(key, &emptyStringKey);
+type CIntKeyMap struct {
+	ConcurrentMap[int, Data]
+	CMap CMap //the map itself
 }
 
-func (m *CIntKeyMap) Put(key int, value Data) Data {
-	return m.kPut(key, &emptyStringKey, value)
+func NewCIntKeyMap(opts ...Option) *CIntKeyMap {
+	m := &CIntKeyMap{}
+	m.CMap = m
+	m.init(IntHasher{}, opts)
+	return m
 }
 
 //--------------------------------------------------------------------------------------
 // map with string key
 //--------------------------------------------------------------------------------------
-type CStrKeyMap struct {
-	CMap
-}
-
-func NewCStrKeyMap() *CStrKeyMap {
-	map_data := &mapData{
-		loadFactor: default_load_factor,
-		threshold: calc_threshold(initial_length, default_load_factor),
-		capacity: initial_length,
-		estimatedCount: 0,
-		data: make([]unsafe.Pointer, initial_length)}
-	m := CStrKeyMap{CMap{keyKind: KT_STRING, mutatorsLock: sync.RWMutex{}}}
-	atomic.StorePointer(&m.data, unsafe.Pointer(map_data))
-	return &m
-}
-
-/* java implementation
-   The value 31 was chosen because it is an odd prime. If it were even and the multiplication overflowed, information
-   would be lost, as multiplication by 2 is equivalent to shifting. The advantage of using a prime is less clear,
-   but it is traditional. A nice property of 31 is that the multiplication can be replaced by a shift and a
-   subtraction for better performance: 31 * i == (i << 5) - i. Modern VMs do this sort of optimization automatically.
-   from pprof disasm:
-      20ms       20ms     471ed1: LEAQ 0x1(CX), SI
-         .          .     471ed5: INCQ BX
-      70ms       70ms     471ed8: MOVZX 0(CX), DI
-         .          .     471edb: MOVQ DX, R8
-      40ms       40ms     471ede: SHLQ $0x5, DX
-      30ms       30ms     471ee2: SUBQ R8, DX
-     150ms      150ms     471ee5: ADDQ DI, DX
-      70ms       70ms     471ee8: MOVQ SI, CX
-      10ms       10ms     471eeb: CMPQ AX, BX
-         .          .     471eee: JL 0x471ed1
-*/
-func strHash(key *string) int  {
-	var h int = 0;
-	for _, c := range []byte(*key) {
-		h = 31 * h + int(c);
-	}
-	return h
+//StrHasher is seeded hash/maphash, seed is random per hasher, so keys with equal hash codes can't be prepared
+//in advance to force long probe sequences. Zero value uses random seed of the process
+type StrHasher struct {
+	seed maphash.Seed
 }
 
-func (m *CStrKeyMap) Get(key string) Data {
-	return m.kGet(strHash(&key), &key);
+var processStrSeed = maphash.MakeSeed()
+
+//NewStrHasher creates hasher with new random seed
+func NewStrHasher() StrHasher {
+	return StrHasher{seed: maphash.MakeSeed()}
 }
 
-func (m *CStrKeyMap) Del(key string) Data {
-	return m.kDel(strHash(&key), &key);
+func (h StrHasher) Hash(key string) uint64 {
+	if h.seed == (maphash.Seed{}) {
+		return maphash.String(processStrSeed, key)
+	}
+	return maphash.String(h.seed, key)
 }
 
-func (m *CStrKeyMap) Put(key string, value Data) Data {
-	return m.kPut(strHash(&key), &key, value)
+type CStrKeyMap struct {
+	ConcurrentMap[string, Data]
+	CMap CMap //the map itself
 }
 
+func NewCStrKeyMap(opts ...Option) *CStrKeyMap {
+	m := &CStrKeyMap{}
+	m.CMap = m
+	m.init(NewStrHasher(), opts)
+	return m
+}
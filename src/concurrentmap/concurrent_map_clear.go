@@ -0,0 +1,93 @@
+package concurrentmap
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+//Clear deletes all entries at one moment: actual mapData is replaced by the empty one,
+//its capacity is chosen by the same rule as capacity of compacted mapData, see newCapacity.
+//Writers are blocked while mapData is replaced, readers and iterators which already took the previous
+//mapData may still see its entries
+func (m *ConcurrentMap[K, V]) Clear() {
+	m.mutatorsLock.Lock()
+	defer m.mutatorsLock.Unlock()
+
+	m.finishTransfers()
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	atomic.StorePointer(&m.data, unsafe.Pointer(newMapData(m.newCapacity(map_data, 0), map_data.loadFactor)))
+	m.count.reset()
+}
+
+//DeleteIf deletes every entry for which fn returns true, returns amount of deleted entries.
+//Entries are walked like by Iterator, concurrent updates are not blocked and entries inserted during the walk
+//may be not seen. Entry is checked by fn again just before delete (value written in place is locked), so fn may be
+//called twice for the same entry and concurrently overwritten entry is deleted only if fn accepts its new value.
+//Map is compacted once after the walk, see newCapacity
+func (m *ConcurrentMap[K, V]) DeleteIf(fn func(key K, value V) bool) int {
+	deleted := 0
+	it := m.Iterator()
+	for it.Next() {
+		if fn(it.current.key, it.value) && m.removeIf(it.current.hash, it.current.key, fn) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		m.compactAfterDelete()
+	}
+	return deleted
+}
+
+//removeIf deletes the key if fn accepts its live entry. Compaction is not started: entries are deleted in order
+//of slots, compaction in the middle of the walk would copy dense not walked part of the slots into smaller mapData
+func (m *ConcurrentMap[K, V]) removeIf(h uint64, key K, fn func(key K, value V) bool) bool {
+	var absent V
+	m.mutatorsLock.RLock()
+	defer m.mutatorsLock.RUnlock()
+
+	for {
+		map_data := m.writableData(h, key)
+		liveEntry, newEntry, result := m._compute(map_data, h, key, func(liveEntry *entry[K, V]) *entry[K, V] {
+			if liveEntry == nil || !fn(liveEntry.key, liveEntry.value) {
+				return nil //key is deleted or changed by other thread
+			}
+			return buildNewEntry(h, key, absent, true)
+		})
+		if result != probeMoved {
+			m.count.add(h, countDelta(liveEntry, newEntry))
+			return newEntry != nil
+		}
+	}
+}
+
+//compactAfterDelete drops deleted entries and decreases capacity if there are too many free slots,
+//helps migration till the end
+func (m *ConcurrentMap[K, V]) compactAfterDelete() {
+	m.mutatorsLock.RLock()
+	defer m.mutatorsLock.RUnlock()
+
+	m.finishTransfers()
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	if !m.ensureCompacted(map_data, atomic.LoadInt32(&map_data.tombstones)) &&
+		m.newCapacity(map_data, m.Len()) < map_data.capacity {
+		m.migrate(map_data, m.Len())
+	}
+	m.finishTransfers()
+}
+
+//Clear deletes all entries of all shards, shards are cleared one by one
+func (m *ShardedCMap[K, V]) Clear() {
+	for _, shard := range m.shards {
+		shard.Clear()
+	}
+}
+
+//DeleteIf deletes every entry of all shards for which fn returns true, returns amount of deleted entries,
+//see ConcurrentMap.DeleteIf
+func (m *ShardedCMap[K, V]) DeleteIf(fn func(key K, value V) bool) int {
+	deleted := 0
+	for _, shard := range m.shards {
+		deleted += shard.DeleteIf(fn)
+	}
+	return deleted
+}
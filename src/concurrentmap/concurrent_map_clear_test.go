@@ -0,0 +1,119 @@
+package concurrentmap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestClear(t *testing.T) {
+	m := NewCIntKeyMap()
+	initialCapacity := m.Stats().Capacity
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i, i)
+	}
+	m.Clear()
+	if m.Len() != 0 || m.Get(1) != nil {
+		t.Errorf("map must be empty after Clear, but found %d entries", m.Len())
+	}
+	if stats := m.Stats(); stats.Capacity != initialCapacity || stats.Used != 0 {
+		t.Errorf("expected empty mapData of capacity %d, but found %+v", initialCapacity, stats)
+	}
+	m.Put(1, 2)
+	if m.Get(1) != 2 || m.Len() != 1 {
+		t.Errorf("map must be usable after Clear")
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+
+	//map which never shrinks keeps its capacity
+	n := NewCIntKeyMap(WithShrinkRate(0))
+	for i := 0; i < keys_to_test; i++ {
+		n.Put(i, i)
+	}
+	capacity := n.Stats().Capacity
+	n.Clear()
+	if n.Stats().Capacity != capacity {
+		t.Errorf("expected capacity %d, but found %d", capacity, n.Stats().Capacity)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	m := NewCIntKeyMap()
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i, i)
+	}
+	capacity := m.Stats().Capacity
+	deleted := m.DeleteIf(func(key int, value Data) bool {
+		return value.(int)%8 != 0
+	})
+	if expected := keys_to_test - keys_to_test/8; deleted != expected {
+		t.Errorf("expected %d deleted entries, but found %d", expected, deleted)
+	}
+	if m.Len() != keys_to_test/8 {
+		t.Errorf("expected %d entries, but found %d", keys_to_test/8, m.Len())
+	}
+	m.Range(func(key int, value Data) bool {
+		if value.(int)%8 != 0 {
+			t.Errorf("entry %d must be deleted", key)
+		}
+		return true
+	})
+	if stats := m.Stats(); stats.Capacity >= capacity || stats.Tombstones != 0 {
+		t.Errorf("map must be compacted to capacity less than %d, but found %+v", capacity, stats)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+}
+
+//all keys are doomed, but concurrent writer overwrites every key by the value which must be kept:
+//DeleteIf may delete the key only before it is overwritten, so all keys must be present at the end
+func TestDeleteIfConcurrent(t *testing.T) {
+	m := NewCIntKeyMap()
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i, -i-1)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := keys_to_test - 1; i >= 0; i-- {
+			m.Put(i, i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		m.DeleteIf(func(key int, value Data) bool {
+			return value.(int) < 0
+		})
+	}()
+	wg.Wait()
+
+	if m.Len() != keys_to_test {
+		t.Errorf("expected %d entries, but found %d", keys_to_test, m.Len())
+	}
+	for i := 0; i < keys_to_test; i++ {
+		if v := m.Get(i); v != i {
+			t.Fatalf("expected value %d of key %d, but found %v", i, i, v)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+}
+
+func TestShardedCMapClear(t *testing.T) {
+	m := NewShardedCIntKeyMap(4)
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i, i)
+	}
+	if deleted := m.DeleteIf(func(key int, value Data) bool { return key%2 == 0 }); deleted != keys_to_test/2 {
+		t.Errorf("expected %d deleted entries, but found %d", keys_to_test/2, deleted)
+	}
+	m.Clear()
+	if m.Len() != 0 {
+		t.Errorf("map must be empty after Clear, but found %d entries", m.Len())
+	}
+}
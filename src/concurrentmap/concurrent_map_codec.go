@@ -0,0 +1,414 @@
+package concurrentmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+   Binary format of CIntKeyMap and CStrKeyMap, all numbers are little endian or varints:
+     header:  magic "CMAP", version uint16, key kind byte, reserved byte, amount of entries uvarint
+     entry:   key (varint for int, uvarint length and bytes for string),
+              expiresAt varint (0 if entry never expires), value length uvarint and bytes of ValueCodec
+     trailer: crc32 (Castagnoli) of header and entries, uint32
+   Entries are written from the consistent snapshot, see concurrent_map_snapshot.go.
+   Loader builds mapData of the final capacity at once and inserts entries without migrations,
+   data is accepted only if the checksum is valid, so truncated or damaged data is never loaded.
+*/
+
+const (
+	codecMagic   = "CMAP"
+	codecVersion = uint16(1)
+
+	codecIntKey = byte(1)
+	codecStrKey = byte(2)
+
+	//length of value or string key above it means damaged data, it protects loader from huge allocations
+	codecMaxLength = 1 << 30
+
+	//entries presized by loader at most, amount of entries is not verified by checksum before the end of data
+	codecMaxPresize = 1 << 16
+)
+
+var (
+	ErrNoValueCodec       = errors.New("concurrentmap: value codec is not set, see WithValueCodec")
+	ErrUnsupportedVersion = errors.New("concurrentmap: unsupported format version")
+	ErrCorruptedData      = errors.New("concurrentmap: corrupted data")
+)
+
+var codecCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//ValueCodec encodes values of the map to bytes and back, values are stored as opaque bytes
+type ValueCodec interface {
+	//AppendValue appends encoded value to buf and returns extended buffer
+	AppendValue(buf []byte, value Data) ([]byte, error)
+	//DecodeValue decodes value encoded by AppendValue
+	DecodeValue(data []byte) (Data, error)
+}
+
+//IntValueCodec encodes int values
+type IntValueCodec struct{}
+
+func (IntValueCodec) AppendValue(buf []byte, value Data) ([]byte, error) {
+	v, ok := value.(int)
+	if !ok {
+		return buf, fmt.Errorf("concurrentmap: IntValueCodec can't encode value of type %T", value)
+	}
+	return binary.AppendVarint(buf, int64(v)), nil
+}
+
+func (IntValueCodec) DecodeValue(data []byte) (Data, error) {
+	v, n := binary.Varint(data)
+	if n <= 0 || n != len(data) {
+		return nil, fmt.Errorf("%w: bad int value", ErrCorruptedData)
+	}
+	if int64(int(v)) != v {
+		return nil, fmt.Errorf("%w: int value %d doesn't fit int of the platform", ErrCorruptedData, v)
+	}
+	return int(v), nil
+}
+
+//StrValueCodec encodes string values
+type StrValueCodec struct{}
+
+func (StrValueCodec) AppendValue(buf []byte, value Data) ([]byte, error) {
+	v, ok := value.(string)
+	if !ok {
+		return buf, fmt.Errorf("concurrentmap: StrValueCodec can't encode value of type %T", value)
+	}
+	return append(buf, v...), nil
+}
+
+func (StrValueCodec) DecodeValue(data []byte) (Data, error) {
+	return string(data), nil
+}
+
+//WithValueCodec sets codec of values used by MarshalBinary, WriteTo and loaders of the map
+func WithValueCodec(codec ValueCodec) Option {
+	return func(o *mapOptions) {
+		o.valueCodec = codec
+	}
+}
+
+//--------------------------------------------------------------------------------------
+// write
+//--------------------------------------------------------------------------------------
+
+type keyAppender[K comparable] func(buf []byte, key K) []byte
+
+func appendIntKey(buf []byte, key int) []byte {
+	return binary.AppendVarint(buf, int64(key))
+}
+
+func appendStrKey(buf []byte, key string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	return append(buf, key...)
+}
+
+//countingWriter counts written bytes and calculates checksum of them
+type countingWriter struct {
+	w       io.Writer
+	crc     uint32
+	written int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.crc = crc32.Update(cw.crc, codecCrcTable, p[:n])
+	cw.written += int64(n)
+	return n, err
+}
+
+func writeMap[K comparable](m *ConcurrentMap[K, Data], w io.Writer, keyKind byte, appendKey keyAppender[K]) (int64, error) {
+	codec := m.options.valueCodec
+	if codec == nil {
+		return 0, ErrNoValueCodec
+	}
+	//values are copied under the lock, entries may be written in place after it
+	entries := make([]entry[K, Data], 0, m.Len())
+	m.liveEntries(func(e *entry[K, Data]) {
+		entries = append(entries, entry[K, Data]{key: e.key, value: e.value, expiresAt: e.expiresAt})
+	})
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, codecMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, codecVersion)
+	buf = append(buf, keyKind, 0)
+	buf = binary.AppendUvarint(buf, uint64(len(entries)))
+	if _, err := cw.Write(buf); err != nil {
+		return cw.written, err
+	}
+
+	var value []byte
+	for _, e := range entries {
+		var err error
+		value, err = codec.AppendValue(value[:0], e.value)
+		if err != nil {
+			return cw.written, err
+		}
+		buf = appendKey(buf[:0], e.key)
+		buf = binary.AppendVarint(buf, e.expiresAt)
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		if _, err := cw.Write(buf); err != nil {
+			return cw.written, err
+		}
+		if _, err := cw.Write(value); err != nil {
+			return cw.written, err
+		}
+	}
+
+	trailer := binary.LittleEndian.AppendUint32(buf[:0], cw.crc)
+	n, err := bw.Write(trailer)
+	cw.written += int64(n)
+	if err != nil {
+		return cw.written, err
+	}
+	return cw.written, bw.Flush()
+}
+
+//WriteTo writes all entries of the map at one moment by value codec of the map, see WithValueCodec
+func (m *CIntKeyMap) WriteTo(w io.Writer) (int64, error) {
+	return writeMap[int](&m.ConcurrentMap, w, codecIntKey, appendIntKey)
+}
+
+//MarshalBinary encodes all entries of the map at one moment by value codec of the map, see WithValueCodec
+func (m *CIntKeyMap) MarshalBinary() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	_, err := m.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+//WriteTo writes all entries of the map at one moment by value codec of the map, see WithValueCodec
+func (m *CStrKeyMap) WriteTo(w io.Writer) (int64, error) {
+	return writeMap[string](&m.ConcurrentMap, w, codecStrKey, appendStrKey)
+}
+
+//MarshalBinary encodes all entries of the map at one moment by value codec of the map, see WithValueCodec
+func (m *CStrKeyMap) MarshalBinary() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	_, err := m.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+//--------------------------------------------------------------------------------------
+// read
+//--------------------------------------------------------------------------------------
+
+type keyReader[K comparable] func(r *checksumReader) (K, error)
+
+//checksumReader calculates checksum of read bytes, end of data inside of the map is reported as ErrUnexpectedEOF
+type checksumReader struct {
+	r     *bufio.Reader
+	crc   uint32
+	ioErr error //last error of the reader, other errors of varint decoding mean overflow
+	one   [1]byte
+}
+
+func (cr *checksumReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err != nil {
+		cr.ioErr = unexpectedEOF(err)
+		return 0, cr.ioErr
+	}
+	cr.one[0] = b
+	cr.crc = crc32.Update(cr.crc, codecCrcTable, cr.one[:])
+	return b, nil
+}
+
+func (cr *checksumReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(cr.r, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	cr.crc = crc32.Update(cr.crc, codecCrcTable, p)
+	return nil
+}
+
+func (cr *checksumReader) readVarint() (int64, error) {
+	v, err := binary.ReadVarint(cr)
+	return v, cr.varintError(err)
+}
+
+func (cr *checksumReader) readUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(cr)
+	return v, cr.varintError(err)
+}
+
+//varintError reports overflow of varint as ErrCorruptedData
+func (cr *checksumReader) varintError(err error) error {
+	if err == nil || err == cr.ioErr || err == io.ErrUnexpectedEOF {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrCorruptedData, err)
+}
+
+func (cr *checksumReader) readLength() (int, error) {
+	v, err := cr.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > codecMaxLength {
+		return 0, fmt.Errorf("%w: length %d is too big", ErrCorruptedData, v)
+	}
+	return int(v), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+//readIntKey rejects key which doesn't fit int, e.g. key written on 64-bit platform is read on 32-bit one
+func readIntKey(r *checksumReader) (int, error) {
+	v, err := r.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if int64(int(v)) != v {
+		return 0, fmt.Errorf("%w: int key %d doesn't fit int of the platform", ErrCorruptedData, v)
+	}
+	return int(v), nil
+}
+
+func readStrKey(r *checksumReader) (string, error) {
+	n, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+	key := make([]byte, n)
+	if err := r.readFull(key); err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+func readMap[K comparable](m *ConcurrentMap[K, Data], r io.Reader, keyKind byte, readKey keyReader[K]) error {
+	codec := m.options.valueCodec
+	if codec == nil {
+		return ErrNoValueCodec
+	}
+	cr := &checksumReader{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(codecMagic)+4)
+	if err := cr.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:len(codecMagic)]) != codecMagic {
+		return fmt.Errorf("%w: bad magic", ErrCorruptedData)
+	}
+	if version := binary.LittleEndian.Uint16(header[len(codecMagic):]); version != codecVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	if kind := header[len(codecMagic)+2]; kind != keyKind {
+		return fmt.Errorf("%w: key kind %d, expected %d", ErrCorruptedData, kind, keyKind)
+	}
+	count, err := cr.readUvarint()
+	if err != nil {
+		return err
+	}
+	if count > uint64(calc_threshold(m.options.maxCapacity, m.options.loadFactor)) {
+		return fmt.Errorf("%w: %d entries exceed max capacity", ErrCorruptedData, count)
+	}
+
+	presize := count
+	if presize > codecMaxPresize {
+		presize = codecMaxPresize
+	}
+	loaded := make([]*entry[K, Data], 0, presize)
+
+	var value []byte
+	for i := uint64(0); i < count; i++ {
+		key, err := readKey(cr)
+		if err != nil {
+			return err
+		}
+		expiresAt, err := cr.readVarint()
+		if err != nil {
+			return err
+		}
+		n, err := cr.readLength()
+		if err != nil {
+			return err
+		}
+		if cap(value) < n {
+			value = make([]byte, n)
+		}
+		value = value[:n]
+		if err := cr.readFull(value); err != nil {
+			return err
+		}
+		v, err := codec.DecodeValue(value)
+		if err != nil {
+			return err
+		}
+
+		loaded = append(loaded, m.buildLiveEntry(m.hasher.Hash(key), key, v, expiresAt))
+	}
+
+	sum := cr.crc
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(cr.r, trailer); err != nil {
+		return unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(trailer) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptedData)
+	}
+
+	//data is valid, mapData is allocated and entries are inserted only now
+	new_capacity := capacityFor(len(loaded), m.options.loadFactor)
+	if new_capacity < m.options.initialCapacity {
+		new_capacity = m.options.initialCapacity
+	}
+	map_data := newMapData(new_capacity, m.options.loadFactor)
+	for _, e := range loaded {
+		if m.expired(e) {
+			continue
+		}
+		if find[K, Data](map_data, e.hash, e.key) != nil {
+			return fmt.Errorf("%w: duplicated key %v", ErrCorruptedData, e.key)
+		}
+		copyEntry(map_data, e)
+		m.count.add(e.hash, 1)
+	}
+	atomic.StorePointer(&m.data, unsafe.Pointer(map_data))
+	return nil
+}
+
+//ReadCIntKeyMap loads map written by WriteTo or MarshalBinary, options must contain the same value codec
+func ReadCIntKeyMap(r io.Reader, opts ...Option) (*CIntKeyMap, error) {
+	m := NewCIntKeyMap(opts...)
+	if err := readMap[int](&m.ConcurrentMap, r, codecIntKey, readIntKey); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+//UnmarshalCIntKeyMap loads map encoded by MarshalBinary, options must contain the same value codec
+func UnmarshalCIntKeyMap(data []byte, opts ...Option) (*CIntKeyMap, error) {
+	return ReadCIntKeyMap(bytes.NewReader(data), opts...)
+}
+
+//ReadCStrKeyMap loads map written by WriteTo or MarshalBinary, options must contain the same value codec
+func ReadCStrKeyMap(r io.Reader, opts ...Option) (*CStrKeyMap, error) {
+	m := NewCStrKeyMap(opts...)
+	if err := readMap[string](&m.ConcurrentMap, r, codecStrKey, readStrKey); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+//UnmarshalCStrKeyMap loads map encoded by MarshalBinary, options must contain the same value codec
+func UnmarshalCStrKeyMap(data []byte, opts ...Option) (*CStrKeyMap, error) {
+	return ReadCStrKeyMap(bytes.NewReader(data), opts...)
+}
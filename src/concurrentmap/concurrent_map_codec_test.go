@@ -0,0 +1,203 @@
+package concurrentmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestMarshalCIntKeyMap(t *testing.T) {
+	m := NewCIntKeyMap(WithValueCodec(IntValueCodec{}))
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i-keys_to_test/2, i)
+	}
+	for i := 0; i < keys_to_test; i += 4 {
+		m.Del(i - keys_to_test/2)
+	}
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := UnmarshalCIntKeyMap(data, WithValueCodec(IntValueCodec{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats := loaded.Stats()
+	if stats.Len != m.Len() || stats.Rehashes != 0 || stats.Capacity != capacityFor(m.Len(), default_load_factor) {
+		t.Errorf("map must be loaded into table of final capacity: %+v", stats)
+	}
+	for i := 0; i < keys_to_test; i++ {
+		key := i - keys_to_test/2
+		if v := loaded.Get(key); v != m.Get(key) {
+			t.Errorf("expected value %v for key %d, but found %v", m.Get(key), key, v)
+		}
+	}
+	VerifyForDoubledValuesCIntKeyMap(loaded)
+}
+
+func TestWriteToCStrKeyMap(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCStrKeyMap(WithValueCodec(StrValueCodec{}), WithClock(clock))
+	for i := 0; i < 1000; i++ {
+		m.Put("key#"+strconv.Itoa(i), "value#"+strconv.Itoa(i))
+	}
+	m.PutWithTTL("short", "short", time.Second)
+	m.PutWithTTL("long", "long", time.Hour)
+
+	buf := &bytes.Buffer{}
+	n, err := m.WriteTo(buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("expected %d written bytes, but found %d, error %v", buf.Len(), n, err)
+	}
+
+	clock.advance(time.Second)
+	loaded, err := ReadCStrKeyMap(buf, WithValueCodec(StrValueCodec{}), WithClock(clock))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded.Len() != 1001 || loaded.Get("short") != nil || loaded.Get("long") != "long" {
+		t.Errorf("expired entry must be skipped on load, len %d", loaded.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if v := loaded.Get("key#" + strconv.Itoa(i)); v != "value#"+strconv.Itoa(i) {
+			t.Errorf("unexpected value %v for key#%d", v, i)
+		}
+	}
+	clock.advance(time.Hour)
+	if loaded.Get("long") != nil {
+		t.Errorf("ttl of entry must be restored")
+	}
+}
+
+func TestUnmarshalRejectsDamagedData(t *testing.T) {
+	m := NewCStrKeyMap(WithValueCodec(StrValueCodec{}))
+	for i := 0; i < 100; i++ {
+		m.Put("key#"+strconv.Itoa(i), "value#"+strconv.Itoa(i))
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	codec := WithValueCodec(StrValueCodec{})
+
+	for l := 0; l < len(data); l++ {
+		if _, err := UnmarshalCStrKeyMap(data[:l], codec); err == nil {
+			t.Fatalf("data truncated to %d of %d bytes must be rejected", l, len(data))
+		} else if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrCorruptedData) {
+			t.Fatalf("unexpected error of data truncated to %d bytes: %v", l, err)
+		}
+	}
+
+	for i := 0; i < len(data); i++ {
+		damaged := append([]byte(nil), data...)
+		damaged[i] ^= 0x10
+		if _, err := UnmarshalCStrKeyMap(damaged, codec); err == nil {
+			t.Fatalf("data damaged at byte %d must be rejected", i)
+		}
+	}
+
+	version := append([]byte(nil), data...)
+	version[len(codecMagic)]++
+	if _, err := UnmarshalCStrKeyMap(version, codec); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("unknown version must be rejected, but found %v", err)
+	}
+	if _, err := UnmarshalCIntKeyMap(data, WithValueCodec(IntValueCodec{})); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("map of other key type must be rejected, but found %v", err)
+	}
+	if _, err := UnmarshalCStrKeyMap(data); !errors.Is(err, ErrNoValueCodec) {
+		t.Errorf("loader without codec must fail, but found %v", err)
+	}
+	if _, err := NewCIntKeyMap().MarshalBinary(); !errors.Is(err, ErrNoValueCodec) {
+		t.Errorf("map without codec must not be encoded, but found %v", err)
+	}
+
+	m.Put("key#0", 0)
+	if _, err := m.MarshalBinary(); err == nil {
+		t.Errorf("value which can't be encoded by codec must fail")
+	}
+}
+
+//amount of entries is not verified before the checksum, loader must not allocate memory for it
+func TestUnmarshalHugeCount(t *testing.T) {
+	header := append([]byte(codecMagic), byte(codecVersion), 0, codecIntKey, 0)
+	header = binary.AppendUvarint(header, 50000000)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := UnmarshalCIntKeyMap(header, WithValueCodec(IntValueCodec{})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated data must be rejected, but found %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+		t.Errorf("loader allocated %d bytes for data of %d bytes", allocated, len(header))
+	}
+}
+
+//tstIntKeyMapData encodes map of one entry with int64 key and value, like map written on 64-bit platform
+func tstIntKeyMapData(key, value int64) []byte {
+	data := append([]byte(codecMagic), byte(codecVersion), 0, codecIntKey, 0)
+	data = binary.AppendUvarint(data, 1)
+	data = binary.AppendVarint(data, key)
+	data = binary.AppendVarint(data, 0)
+	encoded := binary.AppendVarint(nil, value)
+	data = binary.AppendUvarint(data, uint64(len(encoded)))
+	data = append(data, encoded...)
+	return binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, codecCrcTable))
+}
+
+//keys and values above 2^31 written on 64-bit platform are rejected on 32-bit one, not truncated
+func TestUnmarshalIntOverflow(t *testing.T) {
+	big := int64(1) << 40
+	codec := WithValueCodec(IntValueCodec{})
+	if m, err := UnmarshalCIntKeyMap(tstIntKeyMapData(1, 2), codec); err != nil || m.Get(1) != 2 {
+		t.Fatalf("map of small key and value must be loaded, error %v", err)
+	}
+	for _, data := range [][]byte{tstIntKeyMapData(big, 2), tstIntKeyMapData(1, big)} {
+		m, err := UnmarshalCIntKeyMap(data, codec)
+		if unsafe.Sizeof(int(0)) < 8 {
+			if !errors.Is(err, ErrCorruptedData) {
+				t.Errorf("int64 which doesn't fit int must be rejected, but found %v", err)
+			}
+		} else if err != nil || m.Len() != 1 {
+			t.Errorf("int64 key and value must be loaded on 64-bit platform, error %v", err)
+		}
+	}
+}
+
+func BenchmarkReadCIntKeyMap(b *testing.B) {
+	m := NewCIntKeyMap(WithValueCodec(IntValueCodec{}))
+	for i := 0; i < grow_bench_keys; i++ {
+		m.Put(i, i)
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := UnmarshalCIntKeyMap(data, WithValueCodec(IntValueCodec{})); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkPutAllCIntKeyMap(b *testing.B) {
+	entries := make(map[int]Data, grow_bench_keys)
+	for i := 0; i < grow_bench_keys; i++ {
+		entries[i] = i
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		NewCIntKeyMap().PutAll(entries)
+	}
+}
@@ -0,0 +1,286 @@
+package concurrentmap
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+//mutator receives live entry stored for the key (nil if key is absent or deleted)
+//and returns entry to store into the slot, or nil to leave the slot untouched.
+//It may be called several times, if the slot is changed concurrently between read and CAS.
+//Live entry is locked while mutate is called, value of the entry is read directly, not by load()
+type mutator[K comparable, V any] func(liveEntry *entry[K, V]) *entry[K, V]
+
+//_compute finds slot of the key and replaces it by the entry built by mutate, using the same CAS per slot
+//approach as _put and del. Expired entry is deleted before mutate is called. Returns previous live entry, stored entry (nil if mutate refused to store)
+//and probeFull if key is absent and there is no free slot to insert it.
+func (m *ConcurrentMap[K, V]) _compute(map_data *mapData, h uint64, key K, mutate mutator[K, V]) (*entry[K, V], *entry[K, V], probeResult) {
+	map_capacity := map_data.capacity
+	index := hash(h, map_capacity) // compute hashcode
+	reserved := false
+
+	for i := 0; i < map_capacity; i++ {
+		//volatile read, other thread may write in same time
+		entryPtr := atomic.LoadPointer(&map_data.data[index])
+
+		if entryPtr == nil {
+			//end of probe chain, key is absent
+			newEntry := mutate(nil)
+			if newEntry == nil {
+				releaseSlot(map_data, reserved)
+				return nil, nil, probeDone
+			}
+			if !reserved {
+				if result := reserveSlot(map_data); result != probeDone {
+					return nil, nil, result
+				}
+				reserved = true
+			}
+			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, unsafe.Pointer(newEntry)) {
+				return nil, newEntry, probeDone
+			}
+			i-- //slot was taken by other thread, may be by our key, look at it again
+			continue
+		}
+		if entryPtr == movedMarker {
+			releaseSlot(map_data, reserved)
+			return nil, nil, probeMoved
+		}
+
+		oldEntry := (*entry[K, V])(entryPtr)
+		if oldEntry.matches(h, key) {
+			releaseSlot(map_data, reserved)
+			reserved = false
+			if oldEntry.origin != nil {
+				return nil, nil, probeMoved
+			}
+			//live entry which may be written in place is locked, so mutate sees its actual value
+			locked := oldEntry.words != 0 && !oldEntry.deleted
+			if locked && !oldEntry.lock() {
+				i-- //entry is retired, slot is changed by other thread, look at it again
+				continue
+			}
+			if !oldEntry.deleted && isExpiredAt(oldEntry.expiresAt, m.clock) {
+				//expired entry is deleted before, so mutate never sees it
+				if locked {
+					oldEntry.unlock()
+				}
+				m.expire(map_data, index, oldEntry)
+				i-- //look at the slot again
+				continue
+			}
+			liveEntry := oldEntry
+			if oldEntry.deleted {
+				liveEntry = nil
+			}
+			newEntry := mutate(liveEntry)
+			if newEntry == nil {
+				if locked {
+					oldEntry.unlock()
+				}
+				return liveEntry, nil, probeDone
+			}
+			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, unsafe.Pointer(newEntry)) {
+				if locked {
+					oldEntry.retire()
+				}
+				if oldEntry.deleted && !newEntry.deleted {
+					atomic.AddInt32(&map_data.tombstones, -1)
+				} else if !oldEntry.deleted && newEntry.deleted {
+					atomic.AddInt32(&map_data.tombstones, 1)
+				}
+				return liveEntry, newEntry, probeDone
+			}
+			if locked {
+				oldEntry.unlock()
+			}
+			i-- //slot was changed by other thread, look at it again
+			continue
+		}
+
+		//next probe
+		index++
+		if index >= map_capacity {
+			index = 0
+		}
+	}
+	releaseSlot(map_data, reserved)
+	return nil, nil, probeFull
+}
+
+//countDelta returns change of live entries count after replacement of liveEntry by newEntry
+func countDelta[K comparable, V any](liveEntry *entry[K, V], newEntry *entry[K, V]) int64 {
+	if newEntry == nil {
+		return 0
+	}
+	if liveEntry == nil && !newEntry.deleted {
+		return 1
+	}
+	if liveEntry != nil && newEntry.deleted {
+		return -1
+	}
+	return 0
+}
+
+//equalValues compares values by ==, panics if values are not comparable, like sync.Map.CompareAndSwap
+func equalValues[V any](a V, b V) bool {
+	return any(a) == any(b)
+}
+
+//compute applies mutate to the key atomically, if insert is true - map may grow to get free slot for the key
+func (m *ConcurrentMap[K, V]) compute(h uint64, key K, insert bool, mutate mutator[K, V]) (*entry[K, V], *entry[K, V]) {
+	if insert {
+		//start migration to the bigger mapData if grow is really needed
+		m.ensureCapacity()
+	}
+
+	//R-LOCK: concurrent update
+	m.mutatorsLock.RLock()
+	defer m.mutatorsLock.RUnlock()
+
+	for {
+		map_data := m.writableData(h, key)
+		liveEntry, newEntry, result := m._compute(map_data, h, key, mutate)
+		switch result {
+		case probeDone:
+			m.count.add(h, countDelta(liveEntry, newEntry))
+			if newEntry != nil && newEntry.deleted {
+				m.ensureCompacted(map_data, atomic.LoadInt32(&map_data.tombstones))
+			}
+			return liveEntry, newEntry
+		case probeFull:
+			if !insert {
+				return nil, nil //key is absent
+			}
+			//there is no free slot, migrate to the new mapData and try again
+			m.migrateFull(map_data)
+		}
+	}
+}
+
+//PutIfAbsent stores value if key is absent, returns current value of the key or zero value if value was stored
+func (m *ConcurrentMap[K, V]) PutIfAbsent(key K, value V) V {
+	if actual, loaded := m.LoadOrStore(key, value); loaded {
+		return actual
+	}
+	var absent V
+	return absent
+}
+
+//LoadOrStore returns current value of the key and true if key is present,
+//otherwise stores value and returns it and false, like sync.Map LoadOrStore
+func (m *ConcurrentMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	h := m.hasher.Hash(key)
+	var newEntry *entry[K, V]
+	liveEntry, _ := m.compute(h, key, true, func(liveEntry *entry[K, V]) *entry[K, V] {
+		if liveEntry != nil {
+			return nil
+		}
+		if newEntry == nil {
+			newEntry = m.buildLiveEntry(h, key, value, 0)
+		}
+		return newEntry
+	})
+
+	if liveEntry != nil {
+		actual, _ := liveEntry.load()
+		return actual, true
+	}
+	return value, false
+}
+
+//Replace stores newValue if key is associated with oldValue, returns true if value was replaced.
+//Values are compared by ==, panics if values are not comparable
+func (m *ConcurrentMap[K, V]) Replace(key K, oldValue V, newValue V) bool {
+	h := m.hasher.Hash(key)
+	var newEntry *entry[K, V]
+	_, stored := m.compute(h, key, false, func(liveEntry *entry[K, V]) *entry[K, V] {
+		if liveEntry == nil || !equalValues(liveEntry.value, oldValue) {
+			return nil
+		}
+		if newEntry == nil {
+			newEntry = m.buildLiveEntry(h, key, newValue, 0)
+		}
+		return newEntry
+	})
+	return stored != nil
+}
+
+//CompareAndDelete deletes key if it is associated with oldValue, returns true if key was deleted.
+//Values are compared by ==, panics if values are not comparable
+func (m *ConcurrentMap[K, V]) CompareAndDelete(key K, oldValue V) bool {
+	h := m.hasher.Hash(key)
+	var newEntry *entry[K, V]
+	_, stored := m.compute(h, key, false, func(liveEntry *entry[K, V]) *entry[K, V] {
+		if liveEntry == nil || !equalValues(liveEntry.value, oldValue) {
+			return nil
+		}
+		if newEntry == nil {
+			var deletedValue V
+			newEntry = buildNewEntry(h, key, deletedValue, true)
+		}
+		return newEntry
+	})
+	return stored != nil
+}
+
+//ComputeIfAbsent stores value returned by fn if key is absent, returns current value of the key.
+//fn is called at most once and only if key is absent, value is not stored if fn returns false.
+//If other thread inserts the key concurrently, value computed by fn is dropped and actual value is returned.
+//fn is called while mutatorsLock is read-locked, so fn must not access the map: RWMutex is not reentrant,
+//Put, Del or other compute of the same map in fn deadlocks if Clear, Clone, Snapshot, Validate or WriteTo
+//waits for the write lock concurrently
+func (m *ConcurrentMap[K, V]) ComputeIfAbsent(key K, fn func(key K) (V, bool)) V {
+	h := m.hasher.Hash(key)
+	var newEntry *entry[K, V]
+	computed := false
+	liveEntry, stored := m.compute(h, key, true, func(liveEntry *entry[K, V]) *entry[K, V] {
+		if liveEntry != nil {
+			return nil
+		}
+		if !computed {
+			computed = true
+			if value, ok := fn(key); ok {
+				newEntry = m.buildLiveEntry(h, key, value, 0)
+			}
+		}
+		return newEntry
+	})
+
+	if stored != nil {
+		value, _ := stored.load()
+		return value
+	}
+	if liveEntry != nil {
+		value, _ := liveEntry.load()
+		return value
+	}
+	var absent V
+	return absent
+}
+
+//--------------------------------------------------------------------------------------
+// map with integer key
+//--------------------------------------------------------------------------------------
+
+//ComputeIfAbsent stores value returned by fn if key is absent, nil value is not stored.
+//fn must not access the map, see ConcurrentMap.ComputeIfAbsent
+func (m *CIntKeyMap) ComputeIfAbsent(key int, fn func(key int) Data) Data {
+	return m.ConcurrentMap.ComputeIfAbsent(key, func(key int) (Data, bool) {
+		value := fn(key)
+		return value, value != nil
+	})
+}
+
+//--------------------------------------------------------------------------------------
+// map with string key
+//--------------------------------------------------------------------------------------
+
+//ComputeIfAbsent stores value returned by fn if key is absent, nil value is not stored.
+//fn must not access the map, see ConcurrentMap.ComputeIfAbsent
+func (m *CStrKeyMap) ComputeIfAbsent(key string, fn func(key string) Data) Data {
+	return m.ConcurrentMap.ComputeIfAbsent(key, func(key string) (Data, bool) {
+		value := fn(key)
+		return value, value != nil
+	})
+}
@@ -0,0 +1,237 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	compute_test_keys       = 4096
+	compute_test_counters   = 64
+	compute_test_increments = 2000
+)
+
+func TestComputeOpsCIntKeyMap(t *testing.T) {
+	m := NewCIntKeyMap()
+
+	if v := m.PutIfAbsent(1, "v1-0"); v != nil {
+		t.Errorf("PutIfAbsent must store value for absent key, but found %v", v)
+	}
+	if v := m.PutIfAbsent(1, "v1-1"); v != "v1-0" {
+		t.Errorf("PutIfAbsent must return current value v1-0, but found %v", v)
+	}
+
+	if m.Replace(1, "v1-1", "v1-2") {
+		t.Errorf("Replace must fail for unexpected old value")
+	}
+	if !m.Replace(1, "v1-0", "v1-2") {
+		t.Errorf("Replace must succeed for expected old value")
+	}
+	if m.Replace(2, nil, "v2-0") {
+		t.Errorf("Replace must fail for absent key")
+	}
+	if v := m.Get(1); v != "v1-2" {
+		t.Errorf("expected value v1-2, but found %v", v)
+	}
+
+	if m.CompareAndDelete(1, "v1-0") {
+		t.Errorf("CompareAndDelete must fail for unexpected old value")
+	}
+	if !m.CompareAndDelete(1, "v1-2") {
+		t.Errorf("CompareAndDelete must succeed for expected old value")
+	}
+	if m.CompareAndDelete(1, "v1-2") {
+		t.Errorf("CompareAndDelete must fail for deleted key")
+	}
+	if v := m.Get(1); v != nil {
+		t.Errorf("key must be deleted, but found %v", v)
+	}
+
+	calls := 0
+	fn := func(key int) Data {
+		calls++
+		return "v" + strconv.Itoa(key)
+	}
+	if v := m.ComputeIfAbsent(1, fn); v != "v1" {
+		t.Errorf("ComputeIfAbsent must store computed value over deleted key, but found %v", v)
+	}
+	if v := m.ComputeIfAbsent(1, fn); v != "v1" {
+		t.Errorf("ComputeIfAbsent must return current value, but found %v", v)
+	}
+	if calls != 1 {
+		t.Errorf("ComputeIfAbsent must call fn only for absent key, calls: %d", calls)
+	}
+	if v := m.ComputeIfAbsent(3, func(key int) Data { return nil }); v != nil || m.Get(3) != nil {
+		t.Errorf("ComputeIfAbsent mustn't store nil value")
+	}
+
+	if m.GetCount() != 1 {
+		t.Errorf("expected count 1, but found %d", m.GetCount())
+	}
+}
+
+func TestComputeOpsCStrKeyMap(t *testing.T) {
+	m := NewCStrKeyMap()
+
+	if v := m.PutIfAbsent("key#1", "v1-0"); v != nil {
+		t.Errorf("PutIfAbsent must store value for absent key, but found %v", v)
+	}
+	if v := m.PutIfAbsent("key#1", "v1-1"); v != "v1-0" {
+		t.Errorf("PutIfAbsent must return current value v1-0, but found %v", v)
+	}
+	if !m.Replace("key#1", "v1-0", "v1-2") {
+		t.Errorf("Replace must succeed for expected old value")
+	}
+	if !m.CompareAndDelete("key#1", "v1-2") {
+		t.Errorf("CompareAndDelete must succeed for expected old value")
+	}
+	if v := m.ComputeIfAbsent("key#1", func(key string) Data { return key }); v != "key#1" {
+		t.Errorf("ComputeIfAbsent must store computed value, but found %v", v)
+	}
+	if v := m.Get("key#1"); v != "key#1" {
+		t.Errorf("expected value key#1, but found %v", v)
+	}
+}
+
+//every key must be won by exactly one thread, small initial map forces concurrent migration
+func TestPutIfAbsentConcurrent(t *testing.T) {
+	m := NewCIntKeyMap()
+	threadCount := runtime.NumCPU() + 1
+	winners := make([]int32, compute_test_keys)
+	calls := make([]int32, compute_test_keys)
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < compute_test_keys; i++ {
+				if th%2 == 0 {
+					if m.PutIfAbsent(i, th) == nil {
+						atomic.AddInt32(&winners[i], 1)
+					}
+				} else {
+					v := m.ComputeIfAbsent(i, func(key int) Data {
+						atomic.AddInt32(&calls[key], 1)
+						return th
+					})
+					if v == th {
+						atomic.AddInt32(&winners[i], 1)
+					}
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	for i := 0; i < compute_test_keys; i++ {
+		if winners[i] != 1 {
+			t.Errorf("key %d must be stored exactly once, but stored %d times", i, winners[i])
+		}
+		if calls[i] > int32(threadCount/2) {
+			t.Errorf("ComputeIfAbsent fn called too many times for key %d: %d", i, calls[i])
+		}
+	}
+	if m.GetCount() != compute_test_keys {
+		t.Errorf("expected count %d, but found %d", compute_test_keys, m.GetCount())
+	}
+	VerifyForDoubledValuesCIntKeyMap(m)
+}
+
+//fn of ComputeIfAbsent runs under read lock of the map, so fn must not access the map:
+//Snapshot waits for fn, and fn which calls the map would wait for Snapshot
+func TestComputeIfAbsentFnHoldsLock(t *testing.T) {
+	m := NewCIntKeyMap()
+	m.Put(1, 1)
+	entered, release := make(chan bool), make(chan bool)
+	computed := make(chan Data)
+	go func() {
+		computed <- m.ComputeIfAbsent(2, func(key int) Data {
+			if m.mutatorsLock.TryLock() {
+				m.mutatorsLock.Unlock()
+				t.Errorf("fn must be called under read lock of the map")
+			}
+			entered <- true
+			<-release
+			return key
+		})
+	}()
+	<-entered
+
+	snapshot := make(chan map[int]Data)
+	go func() {
+		snapshot <- m.Snapshot()
+	}()
+	var entries map[int]Data
+	select {
+	case entries = <-snapshot:
+		t.Errorf("Snapshot must wait for fn of ComputeIfAbsent")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if v := <-computed; v != 2 {
+		t.Errorf("expected computed value 2, but found %v", v)
+	}
+	if entries == nil {
+		entries = <-snapshot
+	}
+	if len(entries) != 2 {
+		t.Errorf("Snapshot must see computed value, but found %v", entries)
+	}
+}
+
+//counters incremented by Get + Replace loop must not lose updates while other thread forces migration
+func TestReplaceConcurrent(t *testing.T) {
+	m := NewCStrKeyMap()
+	threadCount := runtime.NumCPU() + 1
+	for c := 0; c < compute_test_counters; c++ {
+		m.Put("counter#"+strconv.Itoa(c), 0)
+	}
+
+	finish := int32(0)
+	grow := sync.WaitGroup{}
+	grow.Add(1)
+	go func() {
+		defer grow.Done()
+		for i := 0; atomic.LoadInt32(&finish) == 0; i++ {
+			key := "filler#" + strconv.Itoa(i%compute_test_keys)
+			m.Put(key, i)
+			if i%3 == 0 {
+				m.Del(key)
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < compute_test_increments; i++ {
+				key := "counter#" + strconv.Itoa((th+i)%compute_test_counters)
+				for {
+					v := m.Get(key)
+					if m.Replace(key, v, v.(int)+1) {
+						break
+					}
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+	atomic.StoreInt32(&finish, 1)
+	grow.Wait()
+
+	sum := 0
+	for c := 0; c < compute_test_counters; c++ {
+		sum += m.Get("counter#" + strconv.Itoa(c)).(int)
+	}
+	if sum != threadCount*compute_test_increments {
+		t.Errorf("lost updates, expected sum %d, but found %d", threadCount*compute_test_increments, sum)
+	}
+	VerifyForDoubledValuesCStrKeyMap(m)
+}
@@ -0,0 +1,61 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+const (
+	//amount of counter cells per cpu, more cells - less contention of writers, but slower sum
+	counterCellsPerCpu = 2
+	maxCounterCells    = 64
+)
+
+//counterCell is padded to the cache line, so writers of different cells don't share memory
+type counterCell struct {
+	value int64 //used by atomic operations
+	_     [56]byte
+}
+
+//stripedCounter spreads updates over several cells, idea from java.util.concurrent.atomic.LongAdder.
+//Cell is chosen by hash code of the key, sum is exact if there are no concurrent updates
+type stripedCounter struct {
+	cells []counterCell
+	shift uint //64 - log2(len(cells))
+}
+
+func newStripedCounter() stripedCounter {
+	cells := roundToMinimalPowerOf2(runtime.NumCPU() * counterCellsPerCpu)
+	if cells > maxCounterCells {
+		cells = maxCounterCells
+	}
+	shift := uint(64)
+	for n := cells; n > 1; n >>= 1 {
+		shift--
+	}
+	return stripedCounter{cells: make([]counterCell, cells), shift: shift}
+}
+
+func (c *stripedCounter) add(h uint64, delta int64) {
+	if delta == 0 {
+		return
+	}
+	//fibonacci hashing, high bits of the product depend on all bits of the hash
+	index := (h * fibonacciMultiplier) >> c.shift
+	atomic.AddInt64(&c.cells[index].value, delta)
+}
+
+func (c *stripedCounter) sum() int64 {
+	sum := int64(0)
+	for i := range c.cells {
+		sum += atomic.LoadInt64(&c.cells[i].value)
+	}
+	return sum
+}
+
+//reset sets all cells to zero, it is exact only if there are no concurrent updates
+func (c *stripedCounter) reset() {
+	for i := range c.cells {
+		atomic.StoreInt64(&c.cells[i].value, 0)
+	}
+}
@@ -9,57 +9,42 @@ import (
 //debug only  code below:
 //--------------------------------------------------------------------------------------
 func VerifyForDoubledValuesCIntKeyMap(m *CIntKeyMap) {
-	m.mutatorsLock.Lock()
-	defer m.mutatorsLock.Unlock()
-
-	map_data := (*mapData)(atomic.LoadPointer(&m.data))
-	mp := make(map[int]Data, len(map_data.data))
-
-	for i := 0; i < map_data.capacity; i++ {
-		entryPtr := atomic.LoadPointer(&map_data.data[i])
-		cEntry := (*entry)(entryPtr)
-
-		if cEntry == nil {
-			continue
-		}
-
-		d, found := mp[cEntry.intKey]
-		if !found {
-			mp[cEntry.intKey] = cEntry.value
-		} else {
-			panic(fmt.Sprintf("two value for key fund, key %d, value %v, value %v", cEntry.intKey, d, cEntry.value))
-		}
-	}
+	verifyForDoubledValues(&m.ConcurrentMap)
 }
 
 func VerifyForDoubledValuesCStrKeyMap(m *CStrKeyMap) {
+	verifyForDoubledValues(&m.ConcurrentMap)
+}
+
+func verifyForDoubledValues[K comparable, V any](m *ConcurrentMap[K, V]) {
 	m.mutatorsLock.Lock()
 	defer m.mutatorsLock.Unlock()
 
+	m.finishTransfers()
 	map_data := (*mapData)(atomic.LoadPointer(&m.data))
-	mp := make(map[string]Data, len(map_data.data))
+	mp := make(map[K]V, len(map_data.data))
 
 	for i := 0; i < map_data.capacity; i++ {
 		entryPtr := atomic.LoadPointer(&map_data.data[i])
-		cEntry := (*entry)(entryPtr)
+		cEntry := (*entry[K, V])(entryPtr)
 
 		if cEntry == nil {
 			continue
 		}
 
-		d, found := mp[cEntry.strKey]
+		d, found := mp[cEntry.key]
 		if !found {
-			mp[cEntry.strKey] = cEntry.value
+			mp[cEntry.key] = cEntry.value //writers are blocked, entries are not written in place
 		} else {
-			panic(fmt.Sprintf("two value for key fund, key %s, value %v, value %v", cEntry.strKey, d, cEntry.value))
+			panic(fmt.Sprintf("two value for key fund, key %v, value %v, value %v", cEntry.key, d, cEntry.value))
 		}
 	}
 }
 
 
 func _dump(md *mapData)  {
-	fmt.Printf("map_data[loadFactor: %f, threshold: %d, capacity: %d, estimatedCount: %d, len(data) %d]\n",
-		md.loadFactor, md.threshold, md.capacity, md.estimatedCount, len(md.data))
+	fmt.Printf("map_data[loadFactor: %f, threshold: %d, capacity: %d, tombstones: %d, used: %d, len(data) %d]\n",
+		md.loadFactor, md.threshold, md.capacity, md.tombstones, md.used.Load(), len(md.data))
 }
 
 func DebugCMap() {
@@ -0,0 +1,138 @@
+package concurrentmap
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+/*
+   Expiration of entries, it allows to use the map as concurrent cache.
+   Entry stored by PutWithTTL keeps its expiration time, expired entry is never returned:
+   - readers check expiration time and delete expired entry lazily
+   - writers delete expired entry before they modify its slot, so compute operations never see it
+   - iterator skips expired entries
+   Deleted entries are dropped by compaction, so memory is reclaimed even if expired keys are never read again,
+   if background sweeper is started or Sweep is called periodically.
+*/
+
+//Clock is source of current time for expiration of entries, it may be replaced by fake clock in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+//SetClock replaces clock used for expiration of entries, it must be called before the map is shared between threads
+func (m *ConcurrentMap[K, V]) SetClock(clock Clock) {
+	m.clock = clock
+}
+
+func isExpired[K comparable, V any](e *entry[K, V], clock Clock) bool {
+	//expiration time may be written in place, see concurrent_map_inplace.go
+	return isExpiredAt(atomic.LoadInt64(&e.expiresAt), clock)
+}
+
+func isExpiredAt(expiresAt int64, clock Clock) bool {
+	//entries without ttl don't read the clock
+	return expiresAt != 0 && expiresAt <= clock.Now().UnixNano()
+}
+
+func (m *ConcurrentMap[K, V]) expired(e *entry[K, V]) bool {
+	return isExpired(e, m.clock)
+}
+
+//expire replaces expired entry by deleted entry, returns false if slot was changed by other thread
+//or entry is written in place by new not expired value
+func (m *ConcurrentMap[K, V]) expire(map_data *mapData, index int, oldEntry *entry[K, V]) bool {
+	var absent V
+	newEntry := buildNewEntry(oldEntry.hash, oldEntry.key, absent, true)
+	if oldEntry.words == 0 {
+		if !atomic.CompareAndSwapPointer(&map_data.data[index], unsafe.Pointer(oldEntry), unsafe.Pointer(newEntry)) {
+			return false
+		}
+	} else {
+		if !oldEntry.lock() {
+			return false
+		}
+		if !isExpiredAt(oldEntry.expiresAt, m.clock) ||
+			!atomic.CompareAndSwapPointer(&map_data.data[index], unsafe.Pointer(oldEntry), unsafe.Pointer(newEntry)) {
+			oldEntry.unlock()
+			return false
+		}
+		oldEntry.retire()
+	}
+	m.count.add(oldEntry.hash, -1)
+	m.ensureCompacted(map_data, atomic.AddInt32(&map_data.tombstones, 1))
+	return true
+}
+
+//removeExpired deletes entry of the key if it is expired
+func (m *ConcurrentMap[K, V]) removeExpired(h uint64, key K) {
+	//_compute deletes expired entry before mutate, mutate itself doesn't change anything
+	m.compute(h, key, false, func(liveEntry *entry[K, V]) *entry[K, V] {
+		return nil
+	})
+}
+
+//PutWithTTL stores value which expires after ttl, returns previous not expired value of the key.
+//Value stored with ttl <= 0 never expires, like value stored by Put
+func (m *ConcurrentMap[K, V]) PutWithTTL(key K, value V, ttl time.Duration) V {
+	expiresAt := int64(0)
+	if ttl > 0 {
+		expiresAt = m.clock.Now().Add(ttl).UnixNano()
+	}
+	return m.store(m.hasher.Hash(key), key, value, expiresAt)
+}
+
+//Sweep deletes expired entries, returns amount of found expired entries.
+//It is weakly consistent like Iterator, entries expired during sweep may be left
+func (m *ConcurrentMap[K, V]) Sweep() int {
+	//entries inserted during migration are only in the next mapData, finish migration before
+	m.mutatorsLock.RLock()
+	m.finishTransfers()
+	m.mutatorsLock.RUnlock()
+
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	swept := 0
+	for i := 0; i < map_data.capacity; i++ {
+		entryPtr := atomic.LoadPointer(&map_data.data[i])
+		if entryPtr == nil || entryPtr == movedMarker {
+			continue
+		}
+		e := (*entry[K, V])(entryPtr)
+		if e.origin != nil {
+			e = e.origin
+		}
+		if !e.deleted && m.expired(e) {
+			m.removeExpired(e.hash, e.key)
+			swept++
+		}
+	}
+	return swept
+}
+
+//StartSweeper starts background thread which calls Sweep every interval, returns function to stop it
+func (m *ConcurrentMap[K, V]) StartSweeper(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				m.Sweep()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
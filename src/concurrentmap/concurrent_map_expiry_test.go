@@ -0,0 +1,202 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	expiry_test_keys = 4096
+)
+
+type tstFakeClock struct {
+	nanos int64
+}
+
+func (c *tstFakeClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.nanos))
+}
+
+func (c *tstFakeClock) advance(d time.Duration) {
+	atomic.AddInt64(&c.nanos, int64(d))
+}
+
+func newFakeClock() *tstFakeClock {
+	return &tstFakeClock{nanos: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()}
+}
+
+func TestExpiryCStrKeyMap(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCStrKeyMap()
+	m.SetClock(clock)
+
+	m.PutWithTTL("short", "s", time.Second)
+	m.PutWithTTL("long", "l", time.Minute)
+	m.Put("forever", "f")
+	if m.Get("short") != "s" || m.GetCount() != 3 {
+		t.Errorf("not expired value must be returned")
+	}
+
+	clock.advance(time.Second)
+	if v := m.Get("short"); v != nil {
+		t.Errorf("expired value mustn't be returned, but found %v", v)
+	}
+	if m.GetCount() != 2 {
+		t.Errorf("expired entry must be deleted on get, count %d", m.GetCount())
+	}
+
+	clock.advance(time.Minute)
+	if v := m.Put("long", "l-1"); v != nil {
+		t.Errorf("Put must not return expired value, but found %v", v)
+	}
+	if v := m.Get("long"); v != "l-1" {
+		t.Errorf("Put must clear ttl, but found %v", v)
+	}
+	if m.Get("forever") != "f" {
+		t.Errorf("value without ttl must never expire")
+	}
+
+	m.PutWithTTL("short", "s-1", time.Second)
+	clock.advance(time.Second)
+	if m.Replace("short", "s-1", "s-2") {
+		t.Errorf("Replace must fail for expired value")
+	}
+	m.PutWithTTL("short", "s-3", time.Second)
+	clock.advance(time.Second)
+	if v := m.PutIfAbsent("short", "s-4"); v != nil {
+		t.Errorf("PutIfAbsent must store value over expired one, but found %v", v)
+	}
+	m.PutWithTTL("short", "s-5", time.Second)
+	clock.advance(time.Second)
+	if v := m.Del("short"); v != nil {
+		t.Errorf("Del must not return expired value, but found %v", v)
+	}
+
+	m.PutWithTTL("short", "s-6", time.Second)
+	clock.advance(time.Second)
+	m.Range(func(key string, value Data) bool {
+		if key == "short" {
+			t.Errorf("expired entry mustn't be visited")
+		}
+		return true
+	})
+	if m.GetCount() != 3 {
+		t.Errorf("expected count 3 (with not swept entry), but found %d", m.GetCount())
+	}
+	if swept := m.Sweep(); swept != 1 {
+		t.Errorf("expected 1 swept entry, but found %d", swept)
+	}
+	if m.GetCount() != 2 {
+		t.Errorf("expected count 2, but found %d", m.GetCount())
+	}
+}
+
+//expired entries must be reclaimed by compaction even if they are never read again
+func TestSweepReclaimsMemory(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCIntKeyMap()
+	m.SetClock(clock)
+
+	for round := 0; round < 16; round++ {
+		for i := 0; i < expiry_test_keys; i++ {
+			key := round*expiry_test_keys + i
+			m.PutWithTTL(key, key, time.Second)
+		}
+		clock.advance(time.Second)
+		if swept := m.Sweep(); swept != expiry_test_keys {
+			t.Fatalf("expected %d swept entries, but found %d", expiry_test_keys, swept)
+		}
+	}
+	m.mutatorsLock.RLock()
+	m.finishTransfers()
+	m.mutatorsLock.RUnlock()
+
+	if m.GetCount() != 0 {
+		t.Errorf("all entries must be expired, but count is %d", m.GetCount())
+	}
+	if capacity := mapDataOf(&m.ConcurrentMap).capacity; capacity > 4*expiry_test_keys {
+		t.Errorf("map must not grow with expired entries, capacity %d", capacity)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	m := NewCStrKeyMap()
+	stop := m.StartSweeper(time.Millisecond)
+	defer stop()
+
+	for i := 0; i < expiry_test_keys; i++ {
+		m.PutWithTTL("key#"+strconv.Itoa(i), i, time.Millisecond)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.GetCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if m.GetCount() != 0 {
+		t.Errorf("expired entries must be swept in background, count %d", m.GetCount())
+	}
+}
+
+//reader must never get value which was expired before Get is called
+func TestExpiryConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	m := NewConcurrentMap[int, int64](IntHasher{})
+	m.SetClock(clock)
+	threadCount := runtime.NumCPU() + 1
+
+	//clock doesn't move while writer builds value and puts it, so value is exactly expiration time of the entry
+	clockLock := sync.RWMutex{}
+	finish := int32(0)
+	writers := sync.WaitGroup{}
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for atomic.LoadInt32(&finish) == 0 {
+			clockLock.Lock()
+			clock.advance(time.Millisecond)
+			clockLock.Unlock()
+			runtime.Gosched()
+		}
+	}()
+	for th := 0; th < threadCount; th++ {
+		writers.Add(1)
+		go func(th int) {
+			defer writers.Done()
+			for i := 0; atomic.LoadInt32(&finish) == 0; i++ {
+				key := (i*threadCount + th) % expiry_test_keys
+				ttl := time.Duration(1+i%8) * time.Millisecond
+				clockLock.RLock()
+				m.PutWithTTL(key, clock.Now().Add(ttl).UnixNano(), ttl)
+				clockLock.RUnlock()
+			}
+		}(th)
+	}
+
+	readers := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		readers.Add(1)
+		go func(th int) {
+			defer readers.Done()
+			for i := 0; i < compute_test_increments*10; i++ {
+				now := clock.Now().UnixNano()
+				if expiresAt := m.Get((i * 7) % expiry_test_keys); expiresAt != 0 && expiresAt <= now {
+					t.Errorf("expired value returned, expired at %d, now %d", expiresAt, now)
+					return
+				}
+			}
+		}(th)
+	}
+	readers.Wait()
+	atomic.StoreInt32(&finish, 1)
+	writers.Wait()
+
+	clock.advance(time.Second)
+	m.Sweep()
+	if m.GetCount() != 0 {
+		t.Errorf("all entries must be expired, but count is %d", m.GetCount())
+	}
+	verifyForDoubledValues(m)
+}
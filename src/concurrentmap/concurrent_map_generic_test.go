@@ -0,0 +1,192 @@
+package concurrentmap
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+type (
+	tstUuid [16]byte
+
+	tstUuidHasher struct{}
+
+	tstCompositeKey struct {
+		tenant uint32
+		id     int64
+	}
+
+	tstCompositeKeyHasher struct{}
+
+	tstValue struct {
+		a int
+		b float64
+	}
+
+	tstUint64Hasher struct{}
+)
+
+func (tstUuidHasher) Hash(key tstUuid) uint64 {
+	return binary.LittleEndian.Uint64(key[:8]) ^ binary.LittleEndian.Uint64(key[8:])
+}
+
+func (tstCompositeKeyHasher) Hash(key tstCompositeKey) uint64 {
+	return uint64(key.tenant)*31 + uint64(key.id)
+}
+
+func (tstUint64Hasher) Hash(key uint64) uint64 {
+	return key
+}
+
+func TestConcurrentMapUuidKey(t *testing.T) {
+	m := NewConcurrentMap[tstUuid, tstValue](tstUuidHasher{})
+
+	keys := make([]tstUuid, 1000)
+	for i := range keys {
+		binary.BigEndian.PutUint64(keys[i][8:], uint64(i))
+		keys[i][0] = byte(i % 7)
+		m.Put(keys[i], tstValue{a: i, b: float64(i) / 2})
+	}
+
+	for i := range keys {
+		v := m.Get(keys[i])
+		if v.a != i || v.b != float64(i)/2 {
+			t.Errorf("unexpected value %v for key %v", v, keys[i])
+		}
+	}
+	if v := m.Get(tstUuid{0xff}); v != (tstValue{}) {
+		t.Errorf("absent key must return zero value, but found %v", v)
+	}
+
+	for i := range keys {
+		if i%2 == 0 {
+			if v := m.Del(keys[i]); v.a != i {
+				t.Errorf("Del must return deleted value, but found %v", v)
+			}
+		}
+	}
+	if m.GetCount() != len(keys)/2 {
+		t.Errorf("expected count %d, but found %d", len(keys)/2, m.GetCount())
+	}
+}
+
+func TestConcurrentMapCompositeKey(t *testing.T) {
+	m := NewConcurrentMap[tstCompositeKey, string](tstCompositeKeyHasher{})
+
+	//different keys with the same hash code must not override each other
+	k1 := tstCompositeKey{tenant: 0, id: 31}
+	k2 := tstCompositeKey{tenant: 1, id: 0}
+	m.Put(k1, "k1")
+	m.Put(k2, "k2")
+	if m.Get(k1) != "k1" || m.Get(k2) != "k2" {
+		t.Errorf("keys with equal hash must be stored separately: %v, %v", m.Get(k1), m.Get(k2))
+	}
+
+	if v := m.PutIfAbsent(k1, "k1-1"); v != "k1" {
+		t.Errorf("PutIfAbsent must return current value, but found %v", v)
+	}
+	if !m.Replace(k2, "k2", "k2-1") || m.Get(k2) != "k2-1" {
+		t.Errorf("Replace must store new value")
+	}
+	if v := m.ComputeIfAbsent(tstCompositeKey{tenant: 2}, func(key tstCompositeKey) (string, bool) { return "", true }); v != "" {
+		t.Errorf("ComputeIfAbsent must store zero value if fn allows it, but found %v", v)
+	}
+	if m.GetCount() != 3 {
+		t.Errorf("expected count 3, but found %d", m.GetCount())
+	}
+}
+
+func TestConcurrentMapUint64KeyConcurrent(t *testing.T) {
+	m := NewConcurrentMap[uint64, uint64](tstUint64Hasher{})
+	threadCount := runtime.NumCPU()
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < keys_to_test/threadCount; i++ {
+				key := uint64(i*threadCount + th)
+				m.Put(key, key+1)
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	count := 0
+	m.Range(func(key uint64, value uint64) bool {
+		if value != key+1 {
+			t.Errorf("unexpected value %d for key %d", value, key)
+		}
+		count++
+		return true
+	})
+	if count != (keys_to_test/threadCount)*threadCount {
+		t.Errorf("expected %d keys, but found %d", (keys_to_test/threadCount)*threadCount, count)
+	}
+	verifyForDoubledValues(m)
+}
+
+//nil value is stored like any other value, Load and LoadAndDelete tell it from absent key
+func TestLoadNilValue(t *testing.T) {
+	m := NewCStrKeyMap()
+	m.Put("nil", nil)
+	if v, found := m.Load("nil"); !found || v != nil {
+		t.Errorf("stored nil must be found, but found %v, %t", v, found)
+	}
+	if v, found := m.Load("absent"); found || v != nil {
+		t.Errorf("absent key must not be found, but found %v, %t", v, found)
+	}
+	if m.Len() != 1 {
+		t.Errorf("nil value must be counted, len %d", m.Len())
+	}
+
+	if v, found := m.LoadAndDelete("nil"); !found || v != nil {
+		t.Errorf("stored nil must be deleted, but found %v, %t", v, found)
+	}
+	if _, found := m.LoadAndDelete("nil"); found {
+		t.Errorf("deleted key must not be found")
+	}
+	if _, found := m.Load("nil"); found || m.Len() != 0 {
+		t.Errorf("deleted key must not be found, len %d", m.Len())
+	}
+
+	s := NewShardedCIntKeyMap(4)
+	s.Put(1, nil)
+	if _, found := s.Load(1); !found {
+		t.Errorf("stored nil must be found in sharded map")
+	}
+	if _, found := s.LoadAndDelete(1); !found {
+		t.Errorf("stored nil must be deleted from sharded map")
+	}
+	if _, found := s.Load(1); found {
+		t.Errorf("deleted key must not be found in sharded map")
+	}
+}
+
+//code written for the untyped base of CIntKeyMap and CStrKeyMap still compiles and works
+func TestCMapCompatibility(t *testing.T) {
+	ints := NewCIntKeyMap()
+	ints.Put(1, "a")
+	strs := NewCStrKeyMap()
+	strs.Put("a", 1)
+	strs.Put("b", 2)
+	clone := strs.Clone()
+	clone.Put("c", 3)
+
+	if ints.CMap.GetCount() != 1 || strs.CMap.GetCount() != 2 || clone.CMap.GetCount() != 3 {
+		t.Errorf("CMap field must be the map itself: %d, %d, %d", ints.CMap.GetCount(), strs.CMap.GetCount(), clone.CMap.GetCount())
+	}
+	total := 0
+	for _, m := range []CMap{ints, strs, clone} {
+		total += m.GetCount()
+	}
+	if total != 6 {
+		t.Errorf("expected 6 entries of all maps, but found %d", total)
+	}
+	ints.CMap.Clear()
+	if ints.Len() != 0 {
+		t.Errorf("map must be cleared by CMap, len %d", ints.Len())
+	}
+}
@@ -0,0 +1,218 @@
+package concurrentmap
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+const (
+	//2^adversarial_key_blocks keys with equal strHash
+	adversarial_key_blocks = 12
+	hash_bench_keys        = 1 << adversarial_key_blocks
+
+	//ids with equal low bits, e.g. ids allocated by blocks, uint64: int is 32 bit on 32-bit platforms
+	strided_key_step uint64 = 1 << 32
+)
+
+/* java implementation
+   The value 31 was chosen because it is an odd prime. If it were even and the multiplication overflowed, information
+   would be lost, as multiplication by 2 is equivalent to shifting. The advantage of using a prime is less clear,
+   but it is traditional. A nice property of 31 is that the multiplication can be replaced by a shift and a
+   subtraction for better performance: 31 * i == (i << 5) - i. Modern VMs do this sort of optimization automatically.
+   from pprof disasm:
+      20ms       20ms     471ed1: LEAQ 0x1(CX), SI
+         .          .     471ed5: INCQ BX
+      70ms       70ms     471ed8: MOVZX 0(CX), DI
+         .          .     471edb: MOVQ DX, R8
+      40ms       40ms     471ede: SHLQ $0x5, DX
+      30ms       30ms     471ee2: SUBQ R8, DX
+     150ms      150ms     471ee5: ADDQ DI, DX
+      70ms       70ms     471ee8: MOVQ SI, CX
+      10ms       10ms     471eeb: CMPQ AX, BX
+         .          .     471eee: JL 0x471ed1
+*/
+//strHash is the previous hash of CStrKeyMap, it is trivially collidable: "Aa" and "BB" have equal hash codes
+func strHash(key *string) int  {
+	var h int = 0;
+	for _, c := range []byte(*key) {
+		h = 31 * h + int(c);
+	}
+	return h
+}
+
+//tstJavaStrHasher is the previous hasher of CStrKeyMap, it is kept to compare with seeded hash
+type tstJavaStrHasher struct{}
+
+func (tstJavaStrHasher) Hash(key string) uint64 {
+	return uint64(strHash(&key))
+}
+
+//adversarialKeys builds keys from blocks "Aa" and "BB", 31*'A'+'a' == 31*'B'+'B', so all keys have equal strHash
+func adversarialKeys() []string {
+	keys := []string{""}
+	for b := 0; b < adversarial_key_blocks; b++ {
+		next := make([]string, 0, len(keys)*2)
+		for _, key := range keys {
+			next = append(next, key+"Aa", key+"BB")
+		}
+		keys = next
+	}
+	return keys
+}
+
+//tstJavaIndex is the previous slot function, from java.util.HashMap
+func tstJavaIndex(h uint64, tLen int) int {
+	h = h ^ (h >> 16)
+	return int(h & uint64(tLen-1))
+}
+
+//simulateLongestProbe inserts hash codes into the table of linear probing, returns the longest probe sequence
+func simulateLongestProbe(index func(h uint64, tLen int) int, hashes []uint64, capacity int) int {
+	slots := make([]bool, capacity)
+	longest := 0
+	for _, h := range hashes {
+		i := index(h, capacity)
+		probe := 1
+		for slots[i] {
+			i = (i + 1) & (capacity - 1)
+			probe++
+		}
+		slots[i] = true
+		if probe > longest {
+			longest = probe
+		}
+	}
+	return longest
+}
+
+func stridedKeys() []uint64 {
+	keys := make([]uint64, hash_bench_keys)
+	for i := range keys {
+		keys[i] = uint64(i) * strided_key_step
+	}
+	return keys
+}
+
+func naturalKeys() []string {
+	keys := make([]string, hash_bench_keys)
+	for i := range keys {
+		keys[i] = "user-session#" + strconv.Itoa(i*7919)
+	}
+	return keys
+}
+
+func TestAdversarialKeys(t *testing.T) {
+	keys := adversarialKeys()
+	for _, key := range keys[1:] {
+		if strHash(&key) != strHash(&keys[0]) {
+			t.Fatalf("adversarial keys must collide: %s, %s", key, keys[0])
+		}
+	}
+
+	m := NewCStrKeyMap()
+	for i, key := range keys {
+		m.Put(key, i)
+	}
+	stats := m.Stats()
+	if stats.LongestProbe > 64 {
+		t.Errorf("seeded hash must not be collidable by adversarial keys: %+v", stats)
+	}
+	for i, key := range keys {
+		if m.Get(key) != i {
+			t.Errorf("expected value %d for key %s, but found %v", i, key, m.Get(key))
+		}
+	}
+}
+
+//probe sequences must stay short for keys with equal low bits
+func TestStridedKeysSpread(t *testing.T) {
+	keys := stridedKeys()
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = tstUint64Hasher{}.Hash(key) //key as is, like IntHasher
+	}
+	capacity := capacityFor(len(keys), default_load_factor)
+	fibonacci := simulateLongestProbe(hash, hashes, capacity)
+	java := simulateLongestProbe(tstJavaIndex, hashes, capacity)
+	t.Logf("longest probe of %d strided keys: fibonacci %d, java %d", len(keys), fibonacci, java)
+	if fibonacci > 64 {
+		t.Errorf("strided keys must be spread over the table, longest probe %d", fibonacci)
+	}
+
+	m := NewConcurrentMap[uint64, uint64](tstUint64Hasher{})
+	for _, key := range keys {
+		m.Put(key, key)
+	}
+	if stats := m.Stats(); stats.LongestProbe > 64 {
+		t.Errorf("strided keys must be spread over the table: %+v", stats)
+	}
+}
+
+func TestStrHasherSeed(t *testing.T) {
+	a, b := NewStrHasher(), NewStrHasher()
+	if a.Hash("key") != a.Hash("key") {
+		t.Errorf("hash code must be stable for the hasher")
+	}
+	if a.Hash("key") == b.Hash("key") && a.Hash("other") == b.Hash("other") {
+		t.Errorf("hashers must have different seeds")
+	}
+	if (StrHasher{}).Hash("key") != (StrHasher{}).Hash("key") {
+		t.Errorf("zero hasher must use the seed of the process")
+	}
+}
+
+func benchmarkStrHasher(b *testing.B, hasher Hasher[string], keys []string) {
+	m := NewConcurrentMap[string, int](hasher)
+	for i, key := range keys {
+		m.Put(key, i)
+	}
+	stats := m.Stats()
+	fmt.Printf("b.N --> %d, longest probe --> %d\n", b.N, stats.LongestProbe)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		key := keys[n%len(keys)]
+		if m.Get(key) != n%len(keys) {
+			b.Fatalf("key %s not found", key)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stats.LongestProbe), "longest-probe")
+}
+
+func BenchmarkStrHash_Adversarial(b *testing.B) {
+	benchmarkStrHasher(b, tstJavaStrHasher{}, adversarialKeys())
+}
+
+func BenchmarkMaphash_Adversarial(b *testing.B) {
+	benchmarkStrHasher(b, NewStrHasher(), adversarialKeys())
+}
+
+func BenchmarkStrHash_Natural(b *testing.B) {
+	benchmarkStrHasher(b, tstJavaStrHasher{}, naturalKeys())
+}
+
+func BenchmarkMaphash_Natural(b *testing.B) {
+	benchmarkStrHasher(b, NewStrHasher(), naturalKeys())
+}
+
+func BenchmarkConcurrentMap_StridedKeys(b *testing.B) {
+	keys := stridedKeys()
+	m := NewConcurrentMap[uint64, uint64](tstUint64Hasher{})
+	for _, key := range keys {
+		m.Put(key, key)
+	}
+	stats := m.Stats()
+	fmt.Printf("b.N --> %d, longest probe --> %d\n", b.N, stats.LongestProbe)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		key := keys[n%len(keys)]
+		if m.Get(key) != key {
+			b.Fatalf("key %d not found", key)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stats.LongestProbe), "longest-probe")
+}
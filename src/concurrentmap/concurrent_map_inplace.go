@@ -0,0 +1,135 @@
+package concurrentmap
+
+import (
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+   Overwrite in place: Put of the present key doesn't allocate new entry if value type is made of pointer words
+   (interface like Data, pointer, map, chan, func). Value words and expiration time of the live entry are written
+   by atomic stores under seq, like seqlock:
+   - writer locks the entry (odd seq), writes value and expiration time, unlocks it (next even seq)
+   - reader takes even seq, reads value and expiration time by atomic loads and repeats if seq is changed,
+     so reader never blocks writers, but it waits while concurrent writer of the same key holds the entry
+   - single-word value without expiration time is read by one atomic load, such reader never waits: store of
+     expiration time 0 follows store of the value, so reader which sees 0 sees the value written with it or later
+   - entry which is replaced in its slot (delete, compute, expiration) is locked before CAS of the slot and
+     retired after it, so concurrent overwrite can't write value into the entry which is not in the map anymore
+   Migration doesn't retire entries: the same entry is moved to the next mapData and is still written in place.
+   Entries of other value types are immutable, every Put stores new entry by CAS.
+*/
+
+const (
+	entryLocked  uint64 = 1       //seq is odd while value is written
+	entryRetired uint64 = 1 << 63 //entry is replaced in its slot, value is never written again
+)
+
+//valueWords returns amount of pointer words of V if value of V may be written in place by atomic operations, 0 otherwise
+func valueWords[V any]() uint8 {
+	switch reflect.TypeOf((*V)(nil)).Elem().Kind() {
+	case reflect.Interface:
+		return 2 //type or itab, data
+	case reflect.Pointer, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return 1
+	}
+	return 0
+}
+
+//buildLiveEntry builds entry of the value, it may be written in place if value type allows it
+func (m *ConcurrentMap[K, V]) buildLiveEntry(h uint64, key K, value V, expiresAt int64) *entry[K, V] {
+	e := buildNewEntry(h, key, value, false)
+	e.expiresAt = expiresAt
+	e.words = m.valueWords
+	return e
+}
+
+//load reads value and expiration time of the entry, it must not be called by the thread which locked the entry
+func (e *entry[K, V]) load() (V, int64) {
+	if e.words == 0 {
+		return e.value, e.expiresAt //immutable entry
+	}
+	if e.words == 1 && atomic.LoadInt64(&e.expiresAt) == 0 {
+		//value written with ttl may be seen without it, it is not expired yet
+		return e.loadValue(), 0
+	}
+	for {
+		seq := atomic.LoadUint64(&e.seq)
+		if seq&entryLocked != 0 {
+			runtime.Gosched() //value is written by other thread
+			continue
+		}
+		value := e.loadValue()
+		expiresAt := atomic.LoadInt64(&e.expiresAt)
+		if atomic.LoadUint64(&e.seq) == seq {
+			return value, expiresAt
+		}
+	}
+}
+
+func (e *entry[K, V]) loadValue() V {
+	var value V
+	if e.words == 1 {
+		*(*unsafe.Pointer)(unsafe.Pointer(&value)) = atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&e.value)))
+	} else {
+		src := (*[2]unsafe.Pointer)(unsafe.Pointer(&e.value))
+		dst := (*[2]unsafe.Pointer)(unsafe.Pointer(&value))
+		dst[0] = atomic.LoadPointer(&src[0])
+		dst[1] = atomic.LoadPointer(&src[1])
+	}
+	return value
+}
+
+//lock locks the entry to write value or to replace it in the slot, returns false if entry is retired
+func (e *entry[K, V]) lock() bool {
+	for {
+		seq := atomic.LoadUint64(&e.seq)
+		if seq&entryRetired != 0 {
+			return false
+		}
+		if seq&entryLocked == 0 && atomic.CompareAndSwapUint64(&e.seq, seq, seq+1) {
+			return true
+		}
+		runtime.Gosched()
+	}
+}
+
+func (e *entry[K, V]) unlock() {
+	atomic.AddUint64(&e.seq, 1)
+}
+
+//retire unlocks the entry which is replaced in its slot
+func (e *entry[K, V]) retire() {
+	atomic.StoreUint64(&e.seq, entryRetired)
+}
+
+//store writes value and expiration time of the locked entry
+func (e *entry[K, V]) store(value V, expiresAt int64) {
+	if e.words == 1 {
+		atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&e.value)), *(*unsafe.Pointer)(unsafe.Pointer(&value)))
+	} else {
+		src := (*[2]unsafe.Pointer)(unsafe.Pointer(&value))
+		dst := (*[2]unsafe.Pointer)(unsafe.Pointer(&e.value))
+		atomic.StorePointer(&dst[0], src[0])
+		atomic.StorePointer(&dst[1], src[1])
+	}
+	atomic.StoreInt64(&e.expiresAt, expiresAt)
+}
+
+//replaceEntry replaces oldEntry in the slot by newEntry, returns false if slot was changed by other thread
+func replaceEntry[K comparable, V any](map_data *mapData, index int, oldEntry *entry[K, V], newEntry *entry[K, V]) bool {
+	if oldEntry.words == 0 || oldEntry.deleted {
+		return atomic.CompareAndSwapPointer(&map_data.data[index], unsafe.Pointer(oldEntry), unsafe.Pointer(newEntry))
+	}
+	if !oldEntry.lock() {
+		return false //entry is already replaced
+	}
+	if !atomic.CompareAndSwapPointer(&map_data.data[index], unsafe.Pointer(oldEntry), unsafe.Pointer(newEntry)) {
+		oldEntry.unlock()
+		return false
+	}
+	oldEntry.retire()
+	return true
+}
@@ -0,0 +1,177 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	inplace_test_keys       = 16
+	inplace_test_operations = 20000
+)
+
+func TestOverwriteInPlaceAllocs(t *testing.T) {
+	keys := make([]string, 1000)
+	values := make([]Data, len(keys))
+	m := NewCStrKeyMap()
+	for i := range keys {
+		keys[i] = "key#" + strconv.Itoa(i)
+		values[i] = tstValue{a: i}
+		m.Put(keys[i], values[i])
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		for i, key := range keys {
+			m.Put(key, values[len(values)-1-i])
+			m.PutWithTTL(key, values[i], time.Hour)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("overwrite of Data must not allocate, but found %v allocations", allocs)
+	}
+	for i, key := range keys {
+		if v := m.Get(key); v != values[i] {
+			t.Errorf("expected value %v for key %s, but found %v", values[i], key, v)
+		}
+	}
+
+	p := NewConcurrentMap[int, *tstValue](IntHasher{})
+	ptrs := []*tstValue{{a: 1}, {a: 2}}
+	for i := 0; i < 1000; i++ {
+		p.Put(i, ptrs[0])
+	}
+	if allocs := testing.AllocsPerRun(10, func() {
+		for i := 0; i < 1000; i++ {
+			p.Put(i, ptrs[i%2])
+		}
+	}); allocs != 0 {
+		t.Errorf("overwrite of pointer must not allocate, but found %v allocations", allocs)
+	}
+
+	//values which are not pointer words are stored by new entries
+	v := NewConcurrentMap[int, tstValue](IntHasher{})
+	v.Put(1, tstValue{a: 1})
+	if allocs := testing.AllocsPerRun(10, func() { v.Put(1, tstValue{a: 2}) }); allocs != 1 {
+		t.Errorf("overwrite of struct must allocate new entry, but found %v allocations", allocs)
+	}
+}
+
+//every stored value is unique, so every value must be taken back exactly once:
+//returned by overwrite, delete or replace, or left in the map. Overwrite in place of the entry which is
+//concurrently deleted or replaced would lose the value
+func TestOverwriteInPlaceLosesNothing(t *testing.T) {
+	m := NewCIntKeyMap()
+	threadCount := runtime.NumCPU() + 3
+
+	taken := make([][]int, threadCount)
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			take := func(v Data) {
+				if v != nil {
+					taken[th] = append(taken[th], v.(int))
+				}
+			}
+			for i := 0; i < inplace_test_operations; i++ {
+				key := (i * 7 + th) % inplace_test_keys
+				value := th*inplace_test_operations + i + 1
+				switch (i + th) % 5 {
+				case 0, 1:
+					take(m.Put(key, value))
+				case 2:
+					if v, found := m.LoadAndDelete(key); found {
+						take(v)
+					}
+				case 3:
+					if v := m.Get(key); v != nil && m.Replace(key, v, value) {
+						take(v)
+					} else {
+						taken[th] = append(taken[th], value) //value is not stored
+					}
+				case 4:
+					if v := m.Get(key); v != nil {
+						if v.(int) <= 0 || v.(int) > threadCount*inplace_test_operations {
+							t.Errorf("torn value %v of key %d", v, key)
+						}
+					}
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	seen := make(map[int]int)
+	for _, values := range taken {
+		for _, v := range values {
+			seen[v]++
+		}
+	}
+	m.Range(func(key int, value Data) bool {
+		seen[value.(int)]++
+		return true
+	})
+	for th := 0; th < threadCount; th++ {
+		for i := 0; i < inplace_test_operations; i++ {
+			if (i+th)%5 == 4 || (i+th)%5 == 2 {
+				continue //nothing is stored
+			}
+			value := th*inplace_test_operations + i + 1
+			if seen[value] != 1 {
+				t.Fatalf("value %d is taken %d times", value, seen[value])
+			}
+		}
+	}
+	VerifyForDoubledValuesCIntKeyMap(m)
+}
+
+//reader of pointer value without ttl doesn't wait for the writer which holds the entry
+func TestPointerReaderDoesntWait(t *testing.T) {
+	m := NewConcurrentMap[int, *tstValue](IntHasher{})
+	value := &tstValue{a: 1}
+	m.Put(1, value)
+	var e *entry[int, *tstValue]
+	for _, entryPtr := range mapDataOf(m).data {
+		if entryPtr != nil {
+			e = (*entry[int, *tstValue])(entryPtr)
+		}
+	}
+	if !e.lock() {
+		t.Fatalf("entry must not be retired")
+	}
+	defer e.unlock()
+
+	done := make(chan *tstValue)
+	go func() {
+		done <- m.Get(1)
+	}()
+	select {
+	case v := <-done:
+		if v != value {
+			t.Errorf("expected value %v, but found %v", value, v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reader of pointer value waits for the writer")
+	}
+}
+
+func BenchmarkCStrKeyMap_Overwrite(b *testing.B) {
+	keys := make([]string, hash_bench_keys)
+	values := make([]Data, len(keys))
+	m := NewCStrKeyMap()
+	for i := range keys {
+		keys[i] = "key#" + strconv.Itoa(i)
+		values[i] = i
+		m.Put(keys[i], values[i])
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := n % len(keys)
+		m.Put(keys[i], values[len(keys)-1-i])
+	}
+}
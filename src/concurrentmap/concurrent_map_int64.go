@@ -0,0 +1,71 @@
+package concurrentmap
+
+/*
+   Maps with explicit 64-bit keys. CIntKeyMap is keyed by int, it is 32 bit on 32-bit platforms (arm, 386),
+   so 64-bit ids don't fit there. Hash code of the key is mixed by finalizer of MurmurHash3: every bit of the key
+   changes about half of bits of the hash code, so keys which differ only in high bits (shard or type tag in the top
+   bits of the id) are spread over the slots, counter cells and shards like random keys.
+   Mixer is bijection, different keys always have different hash codes.
+*/
+
+//mix64 is fmix64 of MurmurHash3
+func mix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+type Uint64Hasher struct{}
+
+func (Uint64Hasher) Hash(key uint64) uint64 {
+	return mix64(key)
+}
+
+type Int64Hasher struct{}
+
+func (Int64Hasher) Hash(key int64) uint64 {
+	return mix64(uint64(key))
+}
+
+//--------------------------------------------------------------------------------------
+// map with uint64 key
+//--------------------------------------------------------------------------------------
+type CUint64KeyMap struct {
+	ConcurrentMap[uint64, Data]
+}
+
+func NewCUint64KeyMap(opts ...Option) *CUint64KeyMap {
+	m := &CUint64KeyMap{}
+	m.init(Uint64Hasher{}, opts)
+	return m
+}
+
+//Clone returns independent map with all entries of the map at one moment
+func (m *CUint64KeyMap) Clone() *CUint64KeyMap {
+	clone := &CUint64KeyMap{}
+	m.cloneInto(&clone.ConcurrentMap)
+	return clone
+}
+
+//--------------------------------------------------------------------------------------
+// map with int64 key
+//--------------------------------------------------------------------------------------
+type CInt64KeyMap struct {
+	ConcurrentMap[int64, Data]
+}
+
+func NewCInt64KeyMap(opts ...Option) *CInt64KeyMap {
+	m := &CInt64KeyMap{}
+	m.init(Int64Hasher{}, opts)
+	return m
+}
+
+//Clone returns independent map with all entries of the map at one moment
+func (m *CInt64KeyMap) Clone() *CInt64KeyMap {
+	clone := &CInt64KeyMap{}
+	m.cloneInto(&clone.ConcurrentMap)
+	return clone
+}
@@ -0,0 +1,155 @@
+package concurrentmap
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+const int64_test_keys = 1 << 16
+
+//tstFibonacciInverse returns x, x * fibonacciMultiplier == 1 by modulo 2^64, by Newton iterations
+func tstFibonacciInverse() uint64 {
+	x := fibonacciMultiplier
+	for i := 0; i < 5; i++ {
+		x *= 2 - fibonacciMultiplier*x
+	}
+	return x
+}
+
+//highBitKeys returns keys which differ only in bits above shift
+func highBitKeys(shift uint) []uint64 {
+	keys := make([]uint64, int64_test_keys)
+	for i := range keys {
+		keys[i] = uint64(i) << shift | 0xABCD
+	}
+	return keys
+}
+
+func TestUint64HasherSpread(t *testing.T) {
+	capacity := capacityFor(int64_test_keys, default_load_factor)
+	hashesOf := func(keys []uint64) []uint64 {
+		hashes := make([]uint64, len(keys))
+		for i, key := range keys {
+			hashes[i] = Uint64Hasher{}.Hash(key)
+		}
+		return hashes
+	}
+
+	for _, shift := range []uint{32, 40, 48} {
+		if longest := simulateLongestProbe(hash, hashesOf(highBitKeys(shift)), capacity); longest > 64 {
+			t.Errorf("keys with high bits only must be spread, but longest probe is %d for shift %d", longest, shift)
+		}
+	}
+
+	//multiples of inverse of fibonacci multiplier have sequential products, top bits of them are equal,
+	//without mixer all keys are in one probe chain
+	inverse := tstFibonacciInverse()
+	if inverse*fibonacciMultiplier != 1 {
+		t.Fatalf("wrong inverse %x", inverse)
+	}
+	keys := make([]uint64, int64_test_keys/16) //probes of single chain are quadratic
+	for i := range keys {
+		keys[i] = uint64(i) * inverse
+	}
+	capacity = capacityFor(len(keys), default_load_factor)
+	if longest := simulateLongestProbe(hash, keys, capacity); longest != len(keys) {
+		t.Errorf("expected single probe chain without mixer, but longest probe is %d", longest)
+	}
+	if longest := simulateLongestProbe(hash, hashesOf(keys), capacity); longest > 64 {
+		t.Errorf("keys must be spread by mixer, but longest probe is %d", longest)
+	}
+}
+
+func TestCUint64KeyMap(t *testing.T) {
+	m := NewCUint64KeyMap()
+	keys := append(highBitKeys(48), math.MaxUint64, 0, 1<<63)
+	for i, key := range keys {
+		m.Put(key, i)
+	}
+	if m.Len() != len(keys) {
+		t.Errorf("expected len %d, but found %d", len(keys), m.Len())
+	}
+	for i, key := range keys {
+		if v := m.Get(key); v != i {
+			t.Fatalf("expected value %d of key %x, but found %v", i, key, v)
+		}
+	}
+	if stats := m.Stats(); stats.LongestProbe > 64 {
+		t.Errorf("keys must be spread over the table: %+v", stats)
+	}
+	clone := m.Clone()
+	for i, key := range keys {
+		if i%2 == 0 && m.Del(key) != i {
+			t.Fatalf("key %x must be deleted", key)
+		}
+	}
+	if m.Len() != len(keys)/2 || clone.Len() != len(keys) {
+		t.Errorf("expected len %d and %d of clone, but found %d and %d", len(keys)/2, len(keys), m.Len(), clone.Len())
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+}
+
+func TestCInt64KeyMap(t *testing.T) {
+	m := NewCInt64KeyMap()
+	keys := []int64{0, -1, 1, math.MinInt64, math.MaxInt64, 1 << 32, -1 << 32}
+	for i := int64(1); i < int64_test_keys; i++ {
+		keys = append(keys, i<<40, -i<<40)
+	}
+	for _, key := range keys {
+		m.Put(key, key)
+	}
+	if m.Len() != len(keys) {
+		t.Errorf("expected len %d, but found %d", len(keys), m.Len())
+	}
+	for _, key := range keys {
+		if v := m.Get(key); v != key {
+			t.Fatalf("expected value %d of key %d, but found %v", key, key, v)
+		}
+	}
+	//keys which differ only in the high 32 bits are different keys, they are not truncated
+	if m.Get(1<<32) == m.Get(0) {
+		t.Errorf("keys 1<<32 and 0 must have different values")
+	}
+	if stats := m.Stats(); stats.LongestProbe > 64 {
+		t.Errorf("keys must be spread over the table: %+v", stats)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+}
+
+//concurrent writers grow and compact the map by migration, 64-bit counters of migration must be aligned
+//on 32-bit platforms too, see README for the 32-bit test run
+func TestCUint64KeyMapConcurrentGrow(t *testing.T) {
+	m := NewCUint64KeyMap()
+	threadCount := runtime.NumCPU() + 1
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i, key := range highBitKeys(40) {
+				key += uint64(th)
+				m.Put(key, i)
+				if i%4 == 3 {
+					m.Del(key)
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	if expected := threadCount * (int64_test_keys - int64_test_keys/4); m.Len() != expected {
+		t.Errorf("expected len %d, but found %d", expected, m.Len())
+	}
+	if stats := m.Stats(); stats.Rehashes == 0 {
+		t.Errorf("map must be migrated during test: %+v", stats)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+}
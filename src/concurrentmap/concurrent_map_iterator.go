@@ -0,0 +1,88 @@
+package concurrentmap
+
+import (
+	"sync/atomic"
+)
+
+/*
+   Iteration is weakly consistent and doesn't block writers:
+   iterator captures current mapData once and walks its slots with volatile reads.
+   - every key which is present for the entire iteration is visited exactly once,
+     slots are never moved inside of one mapData and migration fills new mapData,
+     so captured mapData keeps every key in single slot even after concurrent migration,
+     migration to the next mapData freezes slots but keeps their content
+   - key inserted or deleted during iteration may be visited or not, expired entries are not visited
+   - visited value is the value stored in the slot at the moment of visit,
+     after concurrent migration it may be older than value stored in actual mapData
+*/
+type Iterator[K comparable, V any] struct {
+	map_data *mapData
+	clock    Clock
+	index    int
+	current  *entry[K, V]
+	value    V //value of current entry at the moment of visit, entry may be written in place later
+}
+
+//Iterator returns weakly consistent iterator, see Iterator for guarantees
+func (m *ConcurrentMap[K, V]) Iterator() *Iterator[K, V] {
+	//keys inserted during migration are only in the next mapData, finish migration before capture
+	m.mutatorsLock.RLock()
+	m.finishTransfers()
+	m.mutatorsLock.RUnlock()
+
+	return &Iterator[K, V]{map_data: (*mapData)(atomic.LoadPointer(&m.data)), clock: m.clock} //volatile read
+}
+
+//Next moves iterator to the next live entry, returns false if there is no more entries.
+//Must be called before first access to Key and Value
+func (it *Iterator[K, V]) Next() bool {
+	for it.index < it.map_data.capacity {
+		//volatile read, other thread may write in same time
+		entryPtr := atomic.LoadPointer(&it.map_data.data[it.index])
+		it.index++
+
+		if entryPtr == nil || entryPtr == movedMarker {
+			continue
+		}
+		cEntry := (*entry[K, V])(entryPtr)
+		if cEntry.origin != nil {
+			//slot is frozen by migration started after capture, its content is still here
+			cEntry = cEntry.origin
+		}
+		if cEntry.deleted {
+			continue
+		}
+		value, expiresAt := cEntry.load()
+		if !isExpiredAt(expiresAt, it.clock) {
+			it.current = cEntry
+			it.value = value
+			return true
+		}
+	}
+	var absent V
+	it.current = nil
+	it.value = absent
+	return false
+}
+
+func (it *Iterator[K, V]) Key() K {
+	return it.current.key
+}
+
+func (it *Iterator[K, V]) Value() V {
+	return it.value
+}
+
+//Range calls fn for every entry of the map until fn returns false, see Iterator for guarantees
+func (m *ConcurrentMap[K, V]) Range(fn func(key K, value V) bool) {
+	it := m.Iterator()
+	for it.Next() {
+		if !fn(it.current.key, it.value) {
+			return
+		}
+	}
+}
+
+type CIntKeyMapIterator = Iterator[int, Data]
+
+type CStrKeyMapIterator = Iterator[string, Data]
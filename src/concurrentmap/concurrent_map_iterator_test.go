@@ -0,0 +1,118 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	iterator_test_stable_keys = 1024
+	iterator_test_iterations  = 200
+)
+
+func TestRangeCIntKeyMap(t *testing.T) {
+	m := NewCIntKeyMap()
+	for i := 0; i < 100; i++ {
+		m.Put(i, i)
+	}
+	for i := 0; i < 100; i += 2 {
+		m.Del(i)
+	}
+
+	visited := make(map[int]int)
+	m.Range(func(key int, value Data) bool {
+		if key != value.(int) {
+			t.Errorf("unexpected value %v for key %d", value, key)
+		}
+		visited[key]++
+		return true
+	})
+	if len(visited) != 50 {
+		t.Errorf("expected 50 visited keys, but found %d", len(visited))
+	}
+	for key, count := range visited {
+		if key%2 == 0 || count != 1 {
+			t.Errorf("key %d visited %d times", key, count)
+		}
+	}
+
+	count := 0
+	m.Range(func(key int, value Data) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Errorf("Range must stop when fn returns false, visited %d", count)
+	}
+}
+
+func TestIteratorCStrKeyMap(t *testing.T) {
+	m := NewCStrKeyMap()
+	it := m.Iterator()
+	if it.Next() {
+		t.Errorf("empty map mustn't have entries")
+	}
+
+	for i := 0; i < 100; i++ {
+		m.Put("key#"+strconv.Itoa(i), i)
+	}
+
+	visited := make(map[string]int)
+	for it = m.Iterator(); it.Next(); {
+		if it.Key() != "key#"+strconv.Itoa(it.Value().(int)) {
+			t.Errorf("unexpected value %v for key %s", it.Value(), it.Key())
+		}
+		visited[it.Key()]++
+	}
+	if len(visited) != 100 {
+		t.Errorf("expected 100 visited keys, but found %d", len(visited))
+	}
+}
+
+//keys present for the entire iteration must be visited exactly once in spite of concurrent migration
+func TestRangeWithConcurrentRehash(t *testing.T) {
+	m := NewCIntKeyMap()
+	for i := 0; i < iterator_test_stable_keys; i++ {
+		m.Put(i, i)
+	}
+
+	finish := int32(0)
+	wg := sync.WaitGroup{}
+	for th := 0; th < runtime.NumCPU(); th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			base := iterator_test_stable_keys * (th + 1)
+			for i := 0; atomic.LoadInt32(&finish) == 0; i++ {
+				//grow and shrink map, it forces migration in both directions
+				key := base + i%(iterator_test_stable_keys)
+				if (i/iterator_test_stable_keys)%2 == 0 {
+					m.Put(key, key)
+				} else {
+					m.Del(key)
+				}
+			}
+		}(th)
+	}
+
+	for n := 0; n < iterator_test_iterations; n++ {
+		visited := make([]int, iterator_test_stable_keys)
+		m.Range(func(key int, value Data) bool {
+			if key < iterator_test_stable_keys {
+				visited[key]++
+			}
+			return true
+		})
+		for key, count := range visited {
+			if count != 1 {
+				t.Fatalf("stable key %d visited %d times", key, count)
+			}
+		}
+	}
+
+	atomic.StoreInt32(&finish, 1)
+	wg.Wait()
+}
@@ -0,0 +1,94 @@
+package concurrentmap
+
+//Option configures the map created by NewConcurrentMap, NewCIntKeyMap or NewCStrKeyMap
+type Option func(o *mapOptions)
+
+type mapOptions struct {
+	initialEntries int
+	loadFactor     float32
+	shrinkRate     int //0 if map never shrinks
+	maxCapacity    int
+	clock          Clock
+	valueCodec     ValueCodec //used by binary serialization only
+
+	initialCapacity int //calculated from initialEntries and loadFactor
+}
+
+//WithInitialCapacity presizes the map for amount of entries, so map doesn't grow until it is exceeded.
+//Capacity of the map never shrinks below the initial capacity
+func WithInitialCapacity(entries int) Option {
+	return func(o *mapOptions) {
+		if entries < 0 {
+			panic("initial capacity must not be negative")
+		}
+		o.initialEntries = entries
+	}
+}
+
+//WithLoadFactor sets max ratio of occupied slots, map grows when it is exceeded, default is 0.75
+func WithLoadFactor(loadFactor float32) Option {
+	return func(o *mapOptions) {
+		if loadFactor <= 0 || loadFactor >= 1 {
+			panic("load factor must be in (0, 1)")
+		}
+		o.loadFactor = loadFactor
+	}
+}
+
+//WithShrinkRate sets when map decreases capacity: if live entries are less than 1/rate of grow threshold,
+//default is 2, 0 means that map never shrinks
+func WithShrinkRate(rate int) Option {
+	return func(o *mapOptions) {
+		if rate < 0 {
+			panic("shrink rate must not be negative")
+		}
+		o.shrinkRate = rate
+	}
+}
+
+//WithMaxCapacity limits amount of slots, it is rounded down to power of 2.
+//Map fills slots over the load factor at max capacity and panics if there is no free slot at all
+func WithMaxCapacity(slots int) Option {
+	return func(o *mapOptions) {
+		if slots < initial_length || slots > max_length {
+			panic("max capacity is out of range")
+		}
+		o.maxCapacity = roundToMinimalPowerOf2(slots)
+		if o.maxCapacity > slots {
+			o.maxCapacity >>= 1
+		}
+	}
+}
+
+//WithClock sets clock used for expiration of entries
+func WithClock(clock Clock) Option {
+	return func(o *mapOptions) {
+		o.clock = clock
+	}
+}
+
+func buildOptions(opts []Option) mapOptions {
+	o := mapOptions{
+		loadFactor:  default_load_factor,
+		shrinkRate:  decreaseCapacityRate,
+		maxCapacity: max_length,
+		clock:       systemClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	o.initialCapacity = capacityFor(o.initialEntries, o.loadFactor)
+	if o.initialCapacity > o.maxCapacity {
+		panic("initial capacity exceeds max capacity")
+	}
+	return o
+}
+
+//capacityFor returns minimal capacity which threshold is enough for occupied slots
+func capacityFor(occupied int, loadFactor float32) int {
+	capacity := roundToMinimalPowerOf2(int(float32(occupied) / loadFactor))
+	for calc_threshold(capacity, loadFactor) < occupied {
+		capacity <<= 1
+	}
+	return capacity
+}
@@ -0,0 +1,133 @@
+package concurrentmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func expectPanic(t *testing.T, name string, fn func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s must panic", name)
+		}
+	}()
+	fn()
+}
+
+func TestInitialCapacityAndLoadFactor(t *testing.T) {
+	m := NewCIntKeyMap(WithInitialCapacity(keys_to_test), WithLoadFactor(0.5))
+	if stats := m.Stats(); stats.Capacity < 2*keys_to_test {
+		t.Errorf("map must be presized for %d entries with load factor 0.5: %+v", keys_to_test, stats)
+	}
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i, i)
+	}
+	stats := m.Stats()
+	if stats.Rehashes != 0 {
+		t.Errorf("presized map mustn't grow: %+v", stats)
+	}
+	if stats.LoadFactor > 0.5 {
+		t.Errorf("load factor 0.5 is exceeded: %+v", stats)
+	}
+
+	for i := keys_to_test; i < 2*keys_to_test; i++ {
+		m.Put(i, i)
+	}
+	if stats := m.Stats(); stats.Rehashes == 0 || stats.LoadFactor > 0.5 {
+		t.Errorf("map must grow with load factor 0.5: %+v", stats)
+	}
+}
+
+func TestShrinkPolicy(t *testing.T) {
+	fill := func(m *CStrKeyMap) int {
+		for i := 0; i < keys_to_test; i++ {
+			m.Put("key#"+strconv.Itoa(i), i)
+		}
+		grown := m.Stats().Capacity
+		for i := 1; i < keys_to_test; i++ {
+			m.Del("key#" + strconv.Itoa(i))
+		}
+		m.mutatorsLock.RLock()
+		m.finishTransfers()
+		m.mutatorsLock.RUnlock()
+		return grown
+	}
+
+	m := NewCStrKeyMap(WithShrinkRate(0))
+	if grown := fill(m); m.Stats().Capacity != grown {
+		t.Errorf("map mustn't shrink with shrink rate 0, capacity %d -> %d", grown, m.Stats().Capacity)
+	}
+
+	m = NewCStrKeyMap(WithInitialCapacity(1000))
+	initial := m.Stats().Capacity
+	if grown := fill(m); m.Stats().Capacity >= grown || m.Stats().Capacity < initial {
+		t.Errorf("map must shrink, but not below initial capacity %d: %d -> %d", initial, grown, m.Stats().Capacity)
+	}
+	if m.Get("key#0") != 0 || m.Len() != 1 {
+		t.Errorf("live entry must be kept after shrink")
+	}
+}
+
+func TestMaxCapacity(t *testing.T) {
+	m := NewCIntKeyMap(WithMaxCapacity(100))
+	for i := 0; i < 64; i++ {
+		m.Put(i, i)
+	}
+	if stats := m.Stats(); stats.Capacity != 64 || stats.Len != 64 {
+		t.Errorf("map must be filled over load factor at max capacity: %+v", stats)
+	}
+	for i := 0; i < 64; i++ {
+		if m.Get(i) != i {
+			t.Errorf("expected value %d for key %d, but found %v", i, i, m.Get(i))
+		}
+	}
+	expectPanic(t, "Put without free slot", func() { m.Put(64, 64) })
+
+	m.Del(0)
+	m.Put(64, 64)
+	if m.Get(64) != 64 || m.Len() != 64 {
+		t.Errorf("slot of deleted entry must be reused at max capacity")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	expectPanic(t, "load factor 1", func() { NewCIntKeyMap(WithLoadFactor(1)) })
+	expectPanic(t, "load factor 0", func() { NewCIntKeyMap(WithLoadFactor(0)) })
+	expectPanic(t, "negative initial capacity", func() { NewCIntKeyMap(WithInitialCapacity(-1)) })
+	expectPanic(t, "negative shrink rate", func() { NewCIntKeyMap(WithShrinkRate(-1)) })
+	expectPanic(t, "too small max capacity", func() { NewCIntKeyMap(WithMaxCapacity(1)) })
+	expectPanic(t, "initial capacity over max capacity", func() {
+		NewCIntKeyMap(WithInitialCapacity(1000), WithMaxCapacity(512))
+	})
+}
+
+func TestPutAll(t *testing.T) {
+	entries := make(map[string]Data, keys_to_test)
+	for i := 0; i < keys_to_test; i++ {
+		entries["key#"+strconv.Itoa(i)] = i
+	}
+
+	m := NewCStrKeyMap()
+	m.Put("key#0", -1)
+	m.PutAll(entries)
+	if stats := m.Stats(); stats.Rehashes != 1 || stats.Len != keys_to_test {
+		t.Errorf("PutAll must grow map once: %+v", stats)
+	}
+	for key, value := range entries {
+		if v := m.Get(key); v != value {
+			t.Errorf("expected value %v for key %s, but found %v", value, key, v)
+		}
+	}
+	VerifyForDoubledValuesCStrKeyMap(m)
+}
+
+func TestWithClock(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCIntKeyMap(WithClock(clock))
+	m.PutWithTTL(1, 1, time.Second)
+	clock.advance(time.Second)
+	if v := m.Get(1); v != nil {
+		t.Errorf("value must be expired by the clock from options, but found %v", v)
+	}
+}
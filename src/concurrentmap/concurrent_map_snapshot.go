@@ -0,0 +1,89 @@
+package concurrentmap
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+   Snapshot and Clone are consistent: they see the state of the map at one moment.
+   Every writer read-locks mutatorsLock, so write lock stops all writers (readers are not blocked),
+   migration in progress is finished under the lock, after that all entries are in the single actual mapData.
+*/
+
+//liveEntries calls fn for every live entry of the map at one moment, writers are blocked until it is finished,
+//so entries are not written in place while fn is called
+func (m *ConcurrentMap[K, V]) liveEntries(fn func(e *entry[K, V])) {
+	m.mutatorsLock.Lock()
+	defer m.mutatorsLock.Unlock()
+
+	m.finishTransfers()
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	for i := 0; i < map_data.capacity; i++ {
+		cEntry := (*entry[K, V])(atomic.LoadPointer(&map_data.data[i]))
+		if cEntry == nil || cEntry.deleted || isExpiredAt(cEntry.expiresAt, m.clock) {
+			continue
+		}
+		fn(cEntry)
+	}
+}
+
+//Snapshot returns plain map with all entries of the map at one moment, it is not changed by later updates
+func (m *ConcurrentMap[K, V]) Snapshot() map[K]V {
+	snapshot := make(map[K]V, m.Len())
+	m.liveEntries(func(e *entry[K, V]) {
+		snapshot[e.key] = e.value
+	})
+	return snapshot
+}
+
+//Clone returns independent map with all entries of the map at one moment, hasher and options of the map
+func (m *ConcurrentMap[K, V]) Clone() *ConcurrentMap[K, V] {
+	clone := &ConcurrentMap[K, V]{}
+	m.cloneInto(clone)
+	return clone
+}
+
+func (m *ConcurrentMap[K, V]) cloneInto(clone *ConcurrentMap[K, V]) {
+	clone.hasher = m.hasher
+	clone.options = m.options
+	clone.clock = m.clock
+	clone.count = newStripedCounter()
+	clone.valueWords = m.valueWords
+
+	//entries are copied, entries of the map may be written in place after snapshot
+	entries := make([]*entry[K, V], 0, m.Len())
+	m.liveEntries(func(e *entry[K, V]) {
+		entries = append(entries, clone.buildLiveEntry(e.hash, e.key, e.value, e.expiresAt))
+	})
+
+	new_capacity := capacityFor(len(entries), m.options.loadFactor)
+	if new_capacity < m.options.initialCapacity {
+		new_capacity = m.options.initialCapacity
+	}
+	if new_capacity > m.options.maxCapacity {
+		new_capacity = m.options.maxCapacity //entries fit, they were stored in mapData of max capacity
+	}
+	map_data := newMapData(new_capacity, m.options.loadFactor)
+	for _, e := range entries {
+		copyEntry(map_data, e)
+		clone.count.add(e.hash, 1)
+	}
+	atomic.StorePointer(&clone.data, unsafe.Pointer(map_data))
+}
+
+//Clone returns independent map with all entries of the map at one moment
+func (m *CIntKeyMap) Clone() *CIntKeyMap {
+	clone := &CIntKeyMap{}
+	clone.CMap = clone
+	m.cloneInto(&clone.ConcurrentMap)
+	return clone
+}
+
+//Clone returns independent map with all entries of the map at one moment
+func (m *CStrKeyMap) Clone() *CStrKeyMap {
+	clone := &CStrKeyMap{}
+	clone.CMap = clone
+	m.cloneInto(&clone.ConcurrentMap)
+	return clone
+}
@@ -0,0 +1,95 @@
+package concurrentmap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotAndClone(t *testing.T) {
+	m := NewCStrKeyMap()
+	for i := 0; i < keys_to_test; i++ {
+		m.Put("key#"+strconv.Itoa(i), i)
+	}
+	for i := 0; i < keys_to_test; i += 2 {
+		m.Del("key#" + strconv.Itoa(i))
+	}
+
+	snapshot := m.Snapshot()
+	clone := m.Clone()
+	if len(snapshot) != keys_to_test/2 || clone.Len() != keys_to_test/2 {
+		t.Fatalf("expected %d entries, but found snapshot %d, clone %d", keys_to_test/2, len(snapshot), clone.Len())
+	}
+	for i := 1; i < keys_to_test; i += 2 {
+		key := "key#" + strconv.Itoa(i)
+		if snapshot[key] != i || clone.Get(key) != i {
+			t.Errorf("expected value %d for key %s, but found snapshot %v, clone %v", i, key, snapshot[key], clone.Get(key))
+		}
+	}
+	if stats := clone.Stats(); stats.Tombstones != 0 || stats.Capacity > m.Stats().Capacity {
+		t.Errorf("clone must be compact: %+v", stats)
+	}
+
+	//clone and snapshot are independent from the map
+	m.Put("key#1", -1)
+	clone.Put("key#3", -3)
+	clone.Put("key#0", 0)
+	if snapshot["key#1"] != 1 || clone.Get("key#1") != 1 || m.Get("key#3") != 3 || m.Get("key#0") != nil {
+		t.Errorf("updates must not be shared")
+	}
+	VerifyForDoubledValuesCStrKeyMap(clone)
+}
+
+func TestCloneKeepsExpiry(t *testing.T) {
+	clock := newFakeClock()
+	m := NewCIntKeyMap(WithClock(clock))
+	m.PutWithTTL(1, 1, time.Second)
+	m.PutWithTTL(2, 2, time.Minute)
+	clock.advance(time.Second)
+
+	clone := m.Clone()
+	if snapshot := m.Snapshot(); len(snapshot) != 1 || clone.Len() != 1 {
+		t.Errorf("expired entry must be skipped, snapshot %v, clone len %d", snapshot, clone.Len())
+	}
+	clock.advance(time.Minute)
+	if v := clone.Get(2); v != nil {
+		t.Errorf("clone must keep ttl of entries, but found %v", v)
+	}
+}
+
+//writer inserts keys in order, so consistent snapshot must contain all keys before the last one
+func TestSnapshotConsistent(t *testing.T) {
+	m := NewCIntKeyMap()
+	var written int64
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < keys_to_test; i++ {
+			m.Put(i, i)
+			atomic.StoreInt64(&written, int64(i+1))
+		}
+	}()
+
+	for atomic.LoadInt64(&written) < keys_to_test {
+		before := int(atomic.LoadInt64(&written))
+		snapshot := m.Snapshot()
+		if len(snapshot) < before {
+			t.Fatalf("snapshot must contain %d written keys, but found %d", before, len(snapshot))
+		}
+		for i := 0; i < len(snapshot); i++ {
+			if v, found := snapshot[i]; !found || v != i {
+				t.Fatalf("snapshot of %d keys has no key %d", len(snapshot), i)
+			}
+		}
+
+		clone := m.Clone()
+		if l := clone.Len(); (l > 0 && clone.Get(l-1) != l-1) || clone.Get(l) != nil {
+			t.Fatalf("clone of %d keys is not consistent", l)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,46 @@
+package concurrentmap
+
+import (
+	"sync/atomic"
+)
+
+//MapStats is snapshot of the map state for monitoring
+type MapStats struct {
+	Len          int     //live entries
+	Capacity     int     //slots of actual mapData
+	LoadFactor   float32 //live entries / capacity, actual value, not the threshold of grow
+	Used         int     //slots occupied by live and deleted entries
+	Tombstones   int     //deleted entries, they are dropped by compaction
+	LongestProbe int     //longest distance from the slot chosen by hash to the slot of the entry, 1 if entry is in its own slot
+	Rehashes     int64   //started migrations to the new mapData, both grow and compaction
+	Migrating    bool    //migration to the next mapData is in progress
+}
+
+//Stats walks all slots of actual mapData, it is weakly consistent like Iterator and doesn't block writers
+func (m *ConcurrentMap[K, V]) Stats() MapStats {
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	stats := MapStats{
+		Len:        m.Len(),
+		Capacity:   map_data.capacity,
+		Used:       int(map_data.used.Load()),
+		Tombstones: int(atomic.LoadInt32(&map_data.tombstones)),
+		Rehashes:   m.rehashes.Load(),
+		Migrating:  nextData(map_data) != nil}
+	stats.LoadFactor = float32(stats.Len) / float32(stats.Capacity)
+
+	for i := 0; i < map_data.capacity; i++ {
+		entryPtr := atomic.LoadPointer(&map_data.data[i])
+		if entryPtr == nil || entryPtr == movedMarker {
+			continue
+		}
+		e := (*entry[K, V])(entryPtr)
+		probe := i - hash(e.hash, map_data.capacity)
+		if probe < 0 {
+			probe += map_data.capacity //probe sequence wraps around the end of the table
+		}
+		if probe+1 > stats.LongestProbe {
+			stats.LongestProbe = probe + 1
+		}
+	}
+	return stats
+}
@@ -0,0 +1,106 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+type tstCollidingHasher struct{}
+
+func (tstCollidingHasher) Hash(key int) uint64 {
+	return 7
+}
+
+func TestStats(t *testing.T) {
+	m := NewCIntKeyMap()
+	stats := m.Stats()
+	if stats.Len != 0 || stats.Capacity != initial_length || stats.Used != 0 || stats.Rehashes != 0 {
+		t.Errorf("unexpected stats of empty map: %+v", stats)
+	}
+
+	for i := 0; i < keys_to_test; i++ {
+		m.Put(i, i)
+	}
+	for i := 0; i < keys_to_test; i += 8 {
+		m.Del(i)
+	}
+	stats = m.Stats()
+	if stats.Len != keys_to_test-keys_to_test/8 {
+		t.Errorf("expected len %d, but found %d", keys_to_test-keys_to_test/8, stats.Len)
+	}
+	if stats.Capacity < keys_to_test || stats.Rehashes == 0 {
+		t.Errorf("map must grow: %+v", stats)
+	}
+	if stats.LoadFactor != float32(stats.Len)/float32(stats.Capacity) || stats.LoadFactor > default_load_factor {
+		t.Errorf("unexpected load factor: %+v", stats)
+	}
+	if stats.Tombstones == 0 || stats.Used < stats.Len+stats.Tombstones {
+		t.Errorf("deleted entries must be reported: %+v", stats)
+	}
+	if stats.LongestProbe < 1 || stats.LongestProbe > stats.Capacity {
+		t.Errorf("unexpected longest probe: %+v", stats)
+	}
+
+	c := NewConcurrentMap[int, int](tstCollidingHasher{})
+	for i := 0; i < 5; i++ {
+		c.Put(i, i)
+	}
+	if stats := c.Stats(); stats.LongestProbe != 5 {
+		t.Errorf("5 colliding keys must have probe 5, but found %+v", stats)
+	}
+}
+
+//overwrite, resurrection of deleted entry and compute operations must not make count drift
+func TestLenExact(t *testing.T) {
+	m := NewCIntKeyMap()
+	threadCount := runtime.NumCPU() + 1
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < keys_to_test; i++ {
+				key := i % compute_test_keys
+				switch (i + th) % 6 {
+				case 0:
+					m.Put(key, i)
+				case 1:
+					m.Del(key)
+				case 2:
+					m.PutIfAbsent(key, i)
+				case 3:
+					if v := m.Get(key); v != nil {
+						m.CompareAndDelete(key, v)
+					}
+				case 4:
+					if v := m.Get(key); v != nil {
+						m.Replace(key, v, i)
+					}
+				case 5:
+					m.ComputeIfAbsent(key, func(key int) Data { return key })
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	present := 0
+	m.Range(func(key int, value Data) bool {
+		present++
+		return true
+	})
+	if m.Len() != present || m.GetCount() != present {
+		t.Errorf("Len %d must be equal to amount of present keys %d", m.Len(), present)
+	}
+
+	for i := 0; i < compute_test_keys; i++ {
+		m.Put(i, i)
+		m.Put(i, i)
+	}
+	if m.Len() != compute_test_keys {
+		t.Errorf("overwrite must not change len, expected %d, but found %d", compute_test_keys, m.Len())
+	}
+	VerifyForDoubledValuesCIntKeyMap(m)
+}
@@ -0,0 +1,251 @@
+package concurrentmap
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+   Incremental migration of entries to the next mapData, idea from java.util.concurrent.ConcurrentHashMap transfer.
+   It is used to grow the map and to drop deleted entries without stop-the-world rehash: old and next mapData
+   coexist while writers migrate slots chunk by chunk, readers are still lock-free and don't help.
+
+   Migration of the slot freezes it, no one can write to the frozen slot anymore:
+   - empty slot is replaced by movedMarker, probe chain of the old mapData ends here,
+     key which is not found before movedMarker can be found only in the next mapData
+   - entry is replaced by moved entry, it keeps key and refers to original entry by origin,
+     after that original entry is copied to the next mapData if it is not deleted
+
+   Copy never overrides entry of the same key in the next mapData, so copy may be repeated by several
+   threads and slow copy can't override newer value.
+   Writer of the key works in the next mapData only after slot of the key is migrated and copied, so next
+   mapData has actual version of the key if it has any. Reader of the key looks at the next mapData when
+   it finds frozen slot of the key and takes original entry if the key is not copied yet.
+   Migration is finished by thread which completes the last chunk, it makes next mapData actual.
+
+   Copy must always find free slot in the next mapData, but writers insert new keys there in same time.
+   So every insert reserves slot first, and slots of not migrated part of the previous mapData are
+   reserved for their copies. Writer which can't reserve slot helps migration and tries again.
+*/
+
+//transferStride is amount of slots migrated by writer at once, it bounds pause of the writer
+const transferStride = 64
+
+//movedMarker is stored in the empty slot which is migrated to the next mapData
+var movedMarker = unsafe.Pointer(new(byte))
+
+func nextData(map_data *mapData) *mapData {
+	return (*mapData)(atomic.LoadPointer(&map_data.next)) //volatile read
+}
+
+//startTransfer starts migration of map_data to the new mapData of new_capacity, returns false if it is already started
+func startTransfer(map_data *mapData, new_capacity int) bool {
+	if nextData(map_data) != nil {
+		return false //already started
+	}
+	next := newMapData(new_capacity, map_data.loadFactor)
+	next.prev = unsafe.Pointer(map_data) //published by CAS below
+	return atomic.CompareAndSwapPointer(&map_data.next, nil, unsafe.Pointer(next))
+}
+
+//reserveSlot reserves free slot for the new key, returns probeFull if there is no free slot,
+//or probeMoved if free slots are reserved for copies of migration in progress
+func reserveSlot(map_data *mapData) probeResult {
+	used := map_data.used.Add(1)
+	if prev := (*mapData)(atomic.LoadPointer(&map_data.prev)); prev != nil {
+		used += int64(prev.capacity) - prev.transferred.Load()
+		if used > int64(map_data.capacity) {
+			map_data.used.Add(-1)
+			if prev.transferIndex.Load() >= int64(prev.capacity) {
+				//nothing to help, wait for threads which migrate the last chunks
+				runtime.Gosched()
+			}
+			return probeMoved
+		}
+	}
+	if used > int64(map_data.capacity) {
+		map_data.used.Add(-1)
+		return probeFull
+	}
+	return probeDone
+}
+
+//releaseSlot returns slot reserved by reserveSlot, if it was not used
+func releaseSlot(map_data *mapData, reserved bool) {
+	if reserved {
+		map_data.used.Add(-1)
+	}
+}
+
+//migrate starts migration of actual mapData to the mapData with enough capacity for occupied slots
+func (m *ConcurrentMap[K, V]) migrate(map_data *mapData, occupied int) {
+	if atomic.LoadPointer(&m.data) != unsafe.Pointer(map_data) {
+		return //map_data is already replaced
+	}
+	if startTransfer(map_data, m.newCapacity(map_data, occupied)) {
+		m.rehashes.Add(1)
+	}
+}
+
+//ensureCompacted starts migration of actual mapData if there are too many deleted entries,
+//returns false if compaction is not needed
+func (m *ConcurrentMap[K, V]) ensureCompacted(map_data *mapData, tombstones int32) bool {
+	if int(tombstones) < map_data.capacity / tombstonesRate {
+		return false
+	}
+	m.migrate(map_data, m.Len() + 1)
+	return true
+}
+
+//migrateFull starts migration of mapData without free slots, count may be behind concurrent inserts,
+//so all not deleted slots are counted as occupied
+func (m *ConcurrentMap[K, V]) migrateFull(map_data *mapData) {
+	occupied := map_data.capacity - int(atomic.LoadInt32(&map_data.tombstones))
+	if count := m.Len(); count > occupied {
+		occupied = count
+	}
+	m.migrate(map_data, occupied + 1)
+}
+
+//writableData returns mapData where the key may be modified,
+//helps migration in progress and migrates slot of the key before
+func (m *ConcurrentMap[K, V]) writableData(h uint64, key K) *mapData {
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+	for {
+		next := nextData(map_data)
+		if next == nil {
+			return map_data
+		}
+		m.helpTransfer(map_data, next)
+		transferKey[K, V](map_data, next, h, key)
+		map_data = next
+	}
+}
+
+//helpTransfer migrates one chunk of slots, returns false if all chunks are already taken by other threads
+func (m *ConcurrentMap[K, V]) helpTransfer(map_data *mapData, next *mapData) bool {
+	map_capacity := int64(map_data.capacity)
+	if map_data.transferIndex.Load() >= map_capacity {
+		return false
+	}
+	start := map_data.transferIndex.Add(transferStride) - transferStride
+	if start >= map_capacity {
+		return false
+	}
+	end := start + transferStride
+	if end > map_capacity {
+		end = map_capacity
+	}
+
+	for i := start; i < end; i++ {
+		transferSlot[K, V](map_data, next, int(i))
+	}
+
+	if map_data.transferred.Add(end - start) == map_capacity {
+		//the last chunk is done, next mapData is actual now
+		atomic.CompareAndSwapPointer(&m.data, unsafe.Pointer(map_data), unsafe.Pointer(next))
+		atomic.StorePointer(&next.prev, nil) //don't keep previous mapData in memory
+	}
+	return true
+}
+
+//finishTransfers helps migration until actual mapData has no next mapData
+func (m *ConcurrentMap[K, V]) finishTransfers() {
+	for {
+		map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+		next := nextData(map_data)
+		if next == nil {
+			return
+		}
+		if !m.helpTransfer(map_data, next) {
+			//all chunks are taken, wait for other threads
+			runtime.Gosched()
+		}
+	}
+}
+
+//transferKey migrates slot of the key, or the end of its probe chain if key is absent
+func transferKey[K comparable, V any](map_data *mapData, next *mapData, h uint64, key K) {
+	map_capacity := map_data.capacity
+	index := hash(h, map_capacity) // compute hashcode
+
+	for i := 0; i < map_capacity; i++ {
+		entryPtr := atomic.LoadPointer(&map_data.data[index])
+		if entryPtr == nil || entryPtr == movedMarker || (*entry[K, V])(entryPtr).matches(h, key) {
+			transferSlot[K, V](map_data, next, index)
+			return
+		}
+
+		//next probe
+		index++
+		if index >= map_capacity {
+			index = 0
+		}
+	}
+}
+
+//transferSlot freezes the slot and copies its entry to the next mapData
+func transferSlot[K comparable, V any](map_data *mapData, next *mapData, index int) {
+	for {
+		entryPtr := atomic.LoadPointer(&map_data.data[index])
+		if entryPtr == nil {
+			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, movedMarker) {
+				return
+			}
+			continue //slot was taken by other thread
+		}
+		if entryPtr == movedMarker {
+			return
+		}
+
+		oldEntry := (*entry[K, V])(entryPtr)
+		if oldEntry.origin != nil {
+			//already frozen by other thread, but may be is not copied yet
+			copyEntry(next, oldEntry.origin)
+			return
+		}
+
+		movedEntry := &entry[K, V]{hash: oldEntry.hash, key: oldEntry.key, deleted: oldEntry.deleted, origin: oldEntry}
+		if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, unsafe.Pointer(movedEntry)) {
+			copyEntry(next, oldEntry)
+			return
+		}
+		//slot was changed by other thread, look at it again
+	}
+}
+
+//copyEntry inserts entry into the next mapData only if there is no entry of the same key
+func copyEntry[K comparable, V any](map_data *mapData, newEntry *entry[K, V]) {
+	if newEntry.deleted {
+		return
+	}
+
+	map_capacity := map_data.capacity
+	index := hash(newEntry.hash, map_capacity) // compute hashcode
+
+	for i := 0; i < map_capacity; i++ {
+		entryPtr := atomic.LoadPointer(&map_data.data[index])
+		if entryPtr == nil {
+			if atomic.CompareAndSwapPointer(&map_data.data[index], entryPtr, unsafe.Pointer(newEntry)) {
+				map_data.used.Add(1) //slot is reserved by not migrated part of the previous mapData
+				return
+			}
+			i-- //slot was taken by other thread, may be by our key, look at it again
+			continue
+		}
+		if entryPtr == movedMarker {
+			return //mapData is migrated by itself, so entry was copied before
+		}
+		if (*entry[K, V])(entryPtr).matches(newEntry.hash, newEntry.key) {
+			return //key is already copied or written by other thread
+		}
+
+		//next probe
+		index++
+		if index >= map_capacity {
+			index = 0
+		}
+	}
+	panic("Can't copy entry, no capacity in the next mapData")
+}
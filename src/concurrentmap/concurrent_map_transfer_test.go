@@ -0,0 +1,272 @@
+package concurrentmap
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	transfer_test_live_keys  = 512
+	transfer_test_churn_keys = 100000
+
+	grow_bench_keys = 1 << 20
+)
+
+func mapDataOf[K comparable, V any](m *ConcurrentMap[K, V]) *mapData {
+	return (*mapData)(atomic.LoadPointer(&m.data))
+}
+
+//delete-heavy workload must not accumulate deleted entries and must not grow the map
+func TestTombstonesCompaction(t *testing.T) {
+	m := NewCIntKeyMap()
+	for i := 0; i < transfer_test_live_keys; i++ {
+		m.Put(i, i)
+	}
+	initial := mapDataOf(&m.ConcurrentMap)
+
+	compacted := false
+	for i := 0; i < transfer_test_churn_keys; i++ {
+		key := transfer_test_live_keys + i
+		m.Put(key, key)
+		m.Del(key)
+
+		map_data := mapDataOf(&m.ConcurrentMap)
+		if map_data != initial {
+			compacted = true
+		}
+		if map_data.capacity > initial.capacity {
+			t.Fatalf("map mustn't grow with constant amount of keys, capacity %d -> %d", initial.capacity, map_data.capacity)
+		}
+		if nextData(map_data) == nil && int(atomic.LoadInt32(&map_data.tombstones)) > map_data.capacity/tombstonesRate {
+			t.Fatalf("too many deleted entries: %d, capacity %d", map_data.tombstones, map_data.capacity)
+		}
+	}
+	if !compacted {
+		t.Errorf("deleted entries must be dropped by compaction")
+	}
+
+	for i := 0; i < transfer_test_live_keys; i++ {
+		if v := m.Get(i); v != i {
+			t.Errorf("expected value %d for key %d, but found %v", i, i, v)
+		}
+	}
+	if m.GetCount() != transfer_test_live_keys {
+		t.Errorf("expected count %d, but found %d", transfer_test_live_keys, m.GetCount())
+	}
+	VerifyForDoubledValuesCIntKeyMap(m)
+}
+
+//compaction in the middle of the map must shrink it, entries are still reachable
+func TestCompactionShrinks(t *testing.T) {
+	m := NewCStrKeyMap()
+	for i := 0; i < keys_to_test; i++ {
+		m.Put("key#"+strconv.Itoa(i), i)
+	}
+	grown := mapDataOf(&m.ConcurrentMap).capacity
+
+	for i := 0; i < keys_to_test; i++ {
+		if i%16 != 0 {
+			m.Del("key#" + strconv.Itoa(i))
+		}
+	}
+	m.mutatorsLock.RLock()
+	m.finishTransfers()
+	m.mutatorsLock.RUnlock()
+
+	if capacity := mapDataOf(&m.ConcurrentMap).capacity; capacity >= grown {
+		t.Errorf("capacity must be decreased after compaction, %d -> %d", grown, capacity)
+	}
+	for i := 0; i < keys_to_test; i += 16 {
+		if v := m.Get("key#" + strconv.Itoa(i)); v != i {
+			t.Errorf("expected value %d for key#%d, but found %v", i, i, v)
+		}
+	}
+	VerifyForDoubledValuesCStrKeyMap(m)
+}
+
+//readers must always find stable keys and writers must not lose updates while compactions are in progress
+func TestCompactionConcurrent(t *testing.T) {
+	m := NewCIntKeyMap()
+	threadCount := runtime.NumCPU() + 1
+	for i := 0; i < transfer_test_live_keys; i++ {
+		m.Put(i, 0)
+	}
+
+	finish := int32(0)
+	churn := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		churn.Add(1)
+		go func(th int) {
+			defer churn.Done()
+			base := transfer_test_live_keys * (th + 1)
+			for i := 0; atomic.LoadInt32(&finish) == 0; i++ {
+				key := base + i%transfer_test_live_keys
+				m.Put(key, key)
+				m.Del(key)
+			}
+		}(th)
+	}
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < compute_test_increments; i++ {
+				key := (th*7 + i) % transfer_test_live_keys
+				for {
+					v := m.Get(key)
+					if v == nil {
+						t.Errorf("stable key %d not found", key)
+						return
+					}
+					if m.Replace(key, v, v.(int)+1) {
+						break
+					}
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+	atomic.StoreInt32(&finish, 1)
+	churn.Wait()
+
+	sum := 0
+	for i := 0; i < transfer_test_live_keys; i++ {
+		sum += m.Get(i).(int)
+	}
+	if sum != threadCount*compute_test_increments {
+		t.Errorf("lost updates, expected sum %d, but found %d", threadCount*compute_test_increments, sum)
+	}
+	if m.GetCount() != transfer_test_live_keys {
+		t.Errorf("expected count %d, but found %d", transfer_test_live_keys, m.GetCount())
+	}
+	VerifyForDoubledValuesCIntKeyMap(m)
+}
+
+//keys inserted before must be found by readers while map grows by concurrent migrations
+func TestGrowConcurrent(t *testing.T) {
+	m := NewCIntKeyMap()
+	threadCount := runtime.NumCPU() + 1
+	perThread := keys_to_test / threadCount
+	inserted := make([]int32, threadCount)
+
+	finish := int32(0)
+	readers := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		readers.Add(1)
+		go func(th int) {
+			defer readers.Done()
+			for i := 0; atomic.LoadInt32(&finish) == 0; i++ {
+				writer := i % threadCount
+				bound := int(atomic.LoadInt32(&inserted[writer]))
+				if bound == 0 {
+					continue
+				}
+				key := writer*perThread + (i*7)%bound
+				if v := m.Get(key); v != key {
+					t.Errorf("inserted key %d not found during grow, found %v", key, v)
+					return
+				}
+			}
+		}(th)
+	}
+
+	writers := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		writers.Add(1)
+		go func(th int) {
+			defer writers.Done()
+			for i := 0; i < perThread; i++ {
+				key := th*perThread + i
+				m.Put(key, key)
+				atomic.StoreInt32(&inserted[th], int32(i+1))
+			}
+		}(th)
+	}
+	writers.Wait()
+	atomic.StoreInt32(&finish, 1)
+	readers.Wait()
+
+	if m.GetCount() != perThread*threadCount {
+		t.Errorf("expected count %d, but found %d", perThread*threadCount, m.GetCount())
+	}
+	for key := 0; key < perThread*threadCount; key++ {
+		if v := m.Get(key); v != key {
+			t.Errorf("expected value %d for key %d, but found %v", key, key, v)
+		}
+	}
+	VerifyForDoubledValuesCIntKeyMap(m)
+	if map_data := mapDataOf(&m.ConcurrentMap); map_data.threshold < perThread*threadCount {
+		t.Errorf("map must grow, threshold %d is less than count %d", map_data.threshold, perThread*threadCount)
+	}
+}
+
+//benchmarkPutDuringGrowth inserts grow_bench_keys keys into empty map b.N times by several threads
+//and reports percentiles of Put latency, map grows from initial capacity to ~2M slots
+func benchmarkPutDuringGrowth(b *testing.B, newPut func() func(key int)) {
+	threadCount := runtime.NumCPU()
+	fmt.Printf("b.N --> %d, numCPU --> %d\n", b.N, runtime.NumCPU())
+
+	latencies := make([]time.Duration, 0, grow_bench_keys*b.N)
+	mu := sync.Mutex{}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		put := newPut()
+		wg := sync.WaitGroup{}
+		for th := 0; th < threadCount; th++ {
+			wg.Add(1)
+			go func(th int) {
+				defer wg.Done()
+				local := make([]time.Duration, 0, grow_bench_keys/threadCount)
+				for key := th; key < grow_bench_keys; key += threadCount {
+					start := time.Now()
+					put(key)
+					local = append(local, time.Since(start))
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				mu.Unlock()
+			}(th)
+		}
+		wg.Wait()
+	}
+	b.StopTimer()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		return float64(latencies[int(float64(len(latencies)-1)*p)].Nanoseconds())
+	}
+	b.ReportMetric(percentile(0.5), "p50-ns")
+	b.ReportMetric(percentile(0.99), "p99-ns")
+	b.ReportMetric(percentile(0.9999), "p9999-ns")
+	b.ReportMetric(percentile(1), "max-ns")
+}
+
+func BenchmarkCIntKeyMap_PutDuringGrowth(b *testing.B) {
+	benchmarkPutDuringGrowth(b, func() func(key int) {
+		m := NewCIntKeyMap()
+		return func(key int) {
+			m.Put(key, key)
+		}
+	})
+}
+
+//RWMutex and map blocks all writers while map grows
+func BenchmarkRwLock_PutDuringGrowth(b *testing.B) {
+	benchmarkPutDuringGrowth(b, func() func(key int) {
+		mu := new(sync.RWMutex)
+		m := make(map[int]interface{})
+		return func(key int) {
+			mu.Lock()
+			m[key] = key
+			mu.Unlock()
+		}
+	})
+}
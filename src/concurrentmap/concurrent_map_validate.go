@@ -0,0 +1,90 @@
+package concurrentmap
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//max amount of problems reported by Validate, the first ones are enough to investigate
+const maxValidateProblems = 16
+
+//Validate checks invariants of the map and returns all found problems joined, nil if the map is consistent:
+//  - every key has single entry, live or deleted
+//  - every entry is reachable from the slot of its hash code: there is no empty slot in the probe chain before it
+//  - actual mapData has no frozen slots, counters of used slots and deleted entries match the slots
+//  - amount of entries counted by Len matches live entries (expired entries are live until they are deleted)
+//Writers are blocked while the map is validated, migration in progress is finished before
+func (m *ConcurrentMap[K, V]) Validate() error {
+	m.mutatorsLock.Lock()
+	defer m.mutatorsLock.Unlock()
+
+	m.finishTransfers()
+	map_data := (*mapData)(atomic.LoadPointer(&m.data)) //volatile read
+
+	var problems []error
+	report := func(format string, args ...any) {
+		if len(problems) < maxValidateProblems {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	if nextData(map_data) != nil || atomic.LoadPointer(&map_data.prev) != nil {
+		report("actual mapData is linked to other mapData after migration")
+	}
+	if len(map_data.data) != map_data.capacity || map_data.capacity&(map_data.capacity-1) != 0 {
+		report("capacity %d is not power of 2 or doesn't match %d slots", map_data.capacity, len(map_data.data))
+	}
+
+	slots := make(map[K]int, m.Len())
+	used, tombstones, live := 0, 0, 0
+	for i := 0; i < map_data.capacity; i++ {
+		entryPtr := atomic.LoadPointer(&map_data.data[i])
+		if entryPtr == nil {
+			continue
+		}
+		used++
+		if entryPtr == movedMarker {
+			report("slot %d is frozen by migration", i)
+			continue
+		}
+		e := (*entry[K, V])(entryPtr)
+		if e.origin != nil {
+			report("slot %d keeps moved entry of key %v", i, e.key)
+			continue
+		}
+		if e.deleted {
+			tombstones++
+		} else {
+			live++
+		}
+
+		if first, found := slots[e.key]; found {
+			report("key %v has entries in slots %d and %d", e.key, first, i)
+		} else {
+			slots[e.key] = i
+		}
+		if e.hash != m.hasher.Hash(e.key) {
+			report("slot %d: cached hash code of key %v doesn't match hasher", i, e.key)
+		}
+
+		//probe chain from the slot of hash code to the entry must have no empty slot
+		for index := hash(e.hash, map_data.capacity); index != i; index = (index + 1) & (map_data.capacity - 1) {
+			if atomic.LoadPointer(&map_data.data[index]) == nil {
+				report("entry of key %v in slot %d is not reachable, slot %d is empty", e.key, i, index)
+				break
+			}
+		}
+	}
+
+	if stored := map_data.used.Load(); stored != int64(used) {
+		report("used slots counter is %d, but %d slots are used", stored, used)
+	}
+	if stored := atomic.LoadInt32(&map_data.tombstones); stored != int32(tombstones) {
+		report("tombstones counter is %d, but there are %d deleted entries", stored, tombstones)
+	}
+	if count := m.count.sum(); count != int64(live) {
+		report("entries counter is %d, but there are %d live entries", count, live)
+	}
+	return errors.Join(problems...)
+}
@@ -0,0 +1,336 @@
+package concurrentmap
+
+import (
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+const (
+	lin_test_rounds         = 100
+	lin_test_threads        = 4
+	lin_test_ops_per_thread = 6
+	lin_test_keys_per_round = 2
+)
+
+func expectProblem(t *testing.T, m *ConcurrentMap[int, int], problem string) {
+	err := m.Validate()
+	if err == nil || !strings.Contains(err.Error(), problem) {
+		t.Errorf("expected problem %q, but found %v", problem, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := NewCIntKeyMap()
+	threadCount := runtime.NumCPU() + 1
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := 0; i < keys_to_test; i++ {
+				key := (i * 31 + th) % compute_test_keys
+				switch i % 3 {
+				case 0, 1:
+					m.Put(key, i)
+				case 2:
+					m.Del(key)
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+
+	//entries of colliding keys are in slots home, home+1, home+2
+	newColliding := func() (*ConcurrentMap[int, int], *mapData, int) {
+		c := NewConcurrentMap[int, int](tstCollidingHasher{})
+		for i := 0; i < 3; i++ {
+			c.Put(i, i)
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("map must be consistent: %v", err)
+		}
+		map_data := mapDataOf(c)
+		return c, map_data, hash(7, map_data.capacity)
+	}
+
+	c, map_data, home := newColliding()
+	atomic.StorePointer(&map_data.data[(home+1)&(map_data.capacity-1)], nil)
+	expectProblem(t, c, "is not reachable")
+
+	c, map_data, home = newColliding()
+	atomic.StorePointer(&map_data.data[(home+3)&(map_data.capacity-1)], atomic.LoadPointer(&map_data.data[home]))
+	map_data.used.Add(1)
+	expectProblem(t, c, "has entries in slots")
+
+	c, map_data, home = newColliding()
+	c.count.add(0, 1)
+	expectProblem(t, c, "entries counter is 4, but there are 3 live entries")
+
+	c, map_data, home = newColliding()
+	atomic.AddInt32(&map_data.tombstones, 1)
+	expectProblem(t, c, "tombstones counter")
+
+	c, map_data, home = newColliding()
+	atomic.StorePointer(&map_data.data[home], unsafe.Pointer(&entry[int, int]{hash: 8, key: 100}))
+	expectProblem(t, c, "cached hash code")
+}
+
+//migrations started by concurrent writers are not reported as problems
+func TestValidateConcurrentGrow(t *testing.T) {
+	m := NewCIntKeyMap()
+	stop := int32(0)
+	wg := sync.WaitGroup{}
+	for th := 0; th < lin_test_threads; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := th; atomic.LoadInt32(&stop) == 0; i += lin_test_threads {
+				key := i % compute_test_keys
+				m.Put(key, i)
+				if i%3 == 0 {
+					m.Del(key)
+				}
+			}
+		}(th)
+	}
+	for i := 0; i < 20; i++ {
+		if err := m.Validate(); err != nil {
+			t.Errorf("map must be consistent: %v", err)
+			break
+		}
+		runtime.Gosched()
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	if m.Stats().Rehashes == 0 {
+		t.Errorf("map must be migrated during test")
+	}
+}
+
+//--------------------------------------------------------------------------------------
+// linearizability checker
+//--------------------------------------------------------------------------------------
+type tstOpKind byte
+
+const (
+	tstPut tstOpKind = iota
+	tstGet
+	tstDel
+	tstPutIfAbsent
+	tstLoadAndDelete
+	tstCompareAndDelete
+	tstReplace
+	tstOpKinds
+)
+
+//tstOp is operation of the history, call and ret are ticks of the logical clock before and after the operation
+type tstOp struct {
+	kind      tstOpKind
+	key       int
+	arg, arg2 Data
+	ret       Data
+	ok        bool
+	call, end int64
+}
+
+//apply applies op to the sequential model of one key (nil is absent key),
+//returns new state and false if result of op is not possible in this state
+func (op *tstOp) apply(state Data) (Data, bool) {
+	switch op.kind {
+	case tstPut:
+		return op.arg, op.ret == state
+	case tstGet:
+		return state, op.ret == state
+	case tstDel:
+		return nil, op.ret == state
+	case tstPutIfAbsent:
+		if state == nil {
+			return op.arg, op.ret == nil
+		}
+		return state, op.ret == state
+	case tstLoadAndDelete:
+		return nil, op.ret == state && op.ok == (state != nil)
+	case tstCompareAndDelete:
+		if state != nil && state == op.arg {
+			return nil, op.ok
+		}
+		return state, !op.ok
+	case tstReplace:
+		if state != nil && state == op.arg {
+			return op.arg2, op.ok
+		}
+		return state, !op.ok
+	}
+	panic("unknown operation")
+}
+
+func (op *tstOp) run(m *CIntKeyMap, clock *int64) {
+	op.call = atomic.AddInt64(clock, 1)
+	switch op.kind {
+	case tstPut:
+		op.ret = m.Put(op.key, op.arg)
+	case tstGet:
+		op.ret = m.Get(op.key)
+	case tstDel:
+		op.ret = m.Del(op.key)
+	case tstPutIfAbsent:
+		op.ret = m.PutIfAbsent(op.key, op.arg)
+	case tstLoadAndDelete:
+		op.ret, op.ok = m.LoadAndDelete(op.key)
+	case tstCompareAndDelete:
+		op.ok = m.CompareAndDelete(op.key, op.arg)
+	case tstReplace:
+		op.ok = m.Replace(op.key, op.arg, op.arg2)
+	}
+	op.end = atomic.AddInt64(clock, 1)
+}
+
+type tstLinState struct {
+	linearized uint64
+	state      Data
+}
+
+//tstLinearizable checks history of one key by search of Wing and Gong with memoization: operation may be
+//linearized next if it is called before end of every not linearized operation. Up to 64 operations
+func tstLinearizable(history []tstOp) bool {
+	all := uint64(1)<<len(history) - 1
+	failed := make(map[tstLinState]bool)
+
+	var search func(linearized uint64, state Data) bool
+	search = func(linearized uint64, state Data) bool {
+		if linearized == all {
+			return true
+		}
+		if failed[tstLinState{linearized, state}] {
+			return false
+		}
+		minEnd := int64(-1)
+		for i := range history {
+			if linearized&(1<<i) == 0 && (minEnd < 0 || history[i].end < minEnd) {
+				minEnd = history[i].end
+			}
+		}
+		for i := range history {
+			if linearized&(1<<i) != 0 || history[i].call > minEnd {
+				continue
+			}
+			if next, ok := history[i].apply(state); ok && search(linearized|1<<i, next) {
+				return true
+			}
+		}
+		failed[tstLinState{linearized, state}] = true
+		return false
+	}
+	return search(0, nil)
+}
+
+func TestLinearizabilityChecker(t *testing.T) {
+	put := tstOp{kind: tstPut, arg: 1, call: 1, end: 2}
+	lateGet := tstOp{kind: tstGet, ret: nil, call: 3, end: 4}
+	if tstLinearizable([]tstOp{put, lateGet}) {
+		t.Errorf("Get after Put must see the value")
+	}
+	lateGet.call = 0
+	if !tstLinearizable([]tstOp{put, lateGet}) {
+		t.Errorf("Get concurrent with Put may see absent key")
+	}
+	lost := []tstOp{
+		{kind: tstPut, arg: 1, ret: 2, call: 1, end: 4},
+		{kind: tstPut, arg: 2, call: 2, end: 3},
+		{kind: tstLoadAndDelete, ret: 2, ok: true, call: 5, end: 6},
+	}
+	if tstLinearizable(lost) {
+		t.Errorf("value 1 stored after value 2 must be deleted")
+	}
+	lost[2].ret = 1
+	if !tstLinearizable(lost) {
+		t.Errorf("value 1 may be stored after value 2")
+	}
+}
+
+//threads run random operations on few keys, concurrent churn of other keys grows and compacts the map,
+//history of every key must be linearizable
+func TestLinearizability(t *testing.T) {
+	m := NewCIntKeyMap()
+	var clock int64
+
+	stop := int32(0)
+	churned := make(chan bool)
+	go func() {
+		for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+			key := -1 - i%(keys_to_test/8)
+			if i%3 == 2 {
+				m.Del(key)
+			} else {
+				m.Put(key, i)
+			}
+		}
+		churned <- true
+	}()
+
+	for round := 0; round < lin_test_rounds; round++ {
+		firstKey := round * lin_test_keys_per_round
+		//kind and key of every operation are random, so a thread mixes all kinds on the same key, ops are drawn before
+		//threads are started: rand.Rand is not safe for concurrent use
+		rnd := rand.New(rand.NewSource(int64(round)))
+		histories := make([][]tstOp, lin_test_threads)
+		for th := range histories {
+			histories[th] = make([]tstOp, lin_test_ops_per_thread)
+			for i := range histories[th] {
+				value := (round*lin_test_threads+th)*lin_test_ops_per_thread + i + 1
+				histories[th][i] = tstOp{
+					kind: tstOpKind(rnd.Intn(int(tstOpKinds))),
+					key:  firstKey + rnd.Intn(lin_test_keys_per_round),
+					arg:  value,
+					arg2: -value}
+			}
+		}
+		wg := sync.WaitGroup{}
+		for th := 0; th < lin_test_threads; th++ {
+			wg.Add(1)
+			go func(th int) {
+				defer wg.Done()
+				ops := histories[th]
+				for i := range ops {
+					if ops[i].kind == tstCompareAndDelete || ops[i].kind == tstReplace {
+						ops[i].arg = m.Get(ops[i].key) //expected old value, may be already replaced
+					}
+					ops[i].run(m, &clock)
+					if i%2 == 0 {
+						runtime.Gosched()
+					}
+				}
+			}(th)
+		}
+		wg.Wait()
+
+		byKey := make(map[int][]tstOp)
+		for _, ops := range histories {
+			for _, op := range ops {
+				byKey[op.key] = append(byKey[op.key], op)
+			}
+		}
+		for key, history := range byKey {
+			if !tstLinearizable(history) {
+				t.Fatalf("history of key %d is not linearizable: %+v", key, history)
+			}
+		}
+	}
+
+	atomic.StoreInt32(&stop, 1)
+	<-churned
+	if err := m.Validate(); err != nil {
+		t.Errorf("map must be consistent: %v", err)
+	}
+	if m.Stats().Rehashes == 0 {
+		t.Errorf("map must be migrated during test")
+	}
+}
@@ -0,0 +1,122 @@
+package concurrentmap
+
+import (
+	"math/bits"
+)
+
+/*
+   ShardedCMap partitions keys over N independent ConcurrentMap, so writers of different shards
+   don't share mutatorsLock, counter cells and migration. Every shard grows, compacts and shrinks by itself.
+   Shard is chosen by high bits of the mixed hash code, shard uses the rest of bits,
+   so keys of one shard are spread over whole table of the shard.
+*/
+
+const max_shards = 1 << 16
+
+//shardHasher mixes hash code and rotates bits of the shard to the low bits
+type shardHasher[K comparable] struct {
+	hasher    Hasher[K]
+	shardBits int
+}
+
+func (h shardHasher[K]) Hash(key K) uint64 {
+	return bits.RotateLeft64(h.hasher.Hash(key) * fibonacciMultiplier, h.shardBits)
+}
+
+type ShardedCMap[K comparable, V any] struct {
+	hasher shardHasher[K]
+	mask   uint64
+	shards []*ConcurrentMap[K, V]
+}
+
+//NewShardedCMap creates map of shards (rounded up to power of 2), options are applied to every shard,
+//initial capacity is divided between shards
+func NewShardedCMap[K comparable, V any](hasher Hasher[K], shards int, opts ...Option) *ShardedCMap[K, V] {
+	if shards < 1 || shards > max_shards {
+		panic("amount of shards is out of range")
+	}
+	shardBits := bits.Len(uint(shards - 1))
+	shards = 1 << shardBits
+
+	o := buildOptions(opts)
+	shard_opts := append(append([]Option(nil), opts...), WithInitialCapacity(o.initialEntries / shards))
+
+	m := &ShardedCMap[K, V]{
+		hasher: shardHasher[K]{hasher: hasher, shardBits: shardBits},
+		mask:   uint64(shards - 1),
+		shards: make([]*ConcurrentMap[K, V], shards)}
+	for i := range m.shards {
+		m.shards[i] = &ConcurrentMap[K, V]{}
+		m.shards[i].init(m.hasher, shard_opts)
+	}
+	return m
+}
+
+func (m *ShardedCMap[K, V]) shard(h uint64) *ConcurrentMap[K, V] {
+	return m.shards[h & m.mask]
+}
+
+func (m *ShardedCMap[K, V]) Get(key K) V {
+	value, _ := m.Load(key)
+	return value
+}
+
+func (m *ShardedCMap[K, V]) Load(key K) (V, bool) {
+	h := m.hasher.Hash(key)
+	return m.shard(h).load(h, key)
+}
+
+func (m *ShardedCMap[K, V]) Put(key K, value V) V {
+	h := m.hasher.Hash(key)
+	return m.shard(h).store(h, key, value, 0)
+}
+
+func (m *ShardedCMap[K, V]) Del(key K) V {
+	value, _ := m.LoadAndDelete(key)
+	return value
+}
+
+func (m *ShardedCMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	h := m.hasher.Hash(key)
+	return m.shard(h).remove(h, key)
+}
+
+//Len returns amount of entries of all shards, it is exact if there are no concurrent updates
+func (m *ShardedCMap[K, V]) Len() int {
+	count := 0
+	for _, shard := range m.shards {
+		count += shard.Len()
+	}
+	return count
+}
+
+//Range calls fn for every entry until fn returns false, shards are iterated one by one, see Iterator for guarantees
+func (m *ShardedCMap[K, V]) Range(fn func(key K, value V) bool) {
+	for _, shard := range m.shards {
+		stopped := false
+		shard.Range(func(key K, value V) bool {
+			stopped = !fn(key, value)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+//Stats returns stats of every shard
+func (m *ShardedCMap[K, V]) Stats() []MapStats {
+	stats := make([]MapStats, len(m.shards))
+	for i, shard := range m.shards {
+		stats[i] = shard.Stats()
+	}
+	return stats
+}
+
+func NewShardedCIntKeyMap(shards int, opts ...Option) *ShardedCMap[int, Data] {
+	return NewShardedCMap[int, Data](IntHasher{}, shards, opts...)
+}
+
+func NewShardedCStrKeyMap(shards int, opts ...Option) *ShardedCMap[string, Data] {
+	return NewShardedCMap[string, Data](NewStrHasher(), shards, opts...)
+}
@@ -0,0 +1,138 @@
+package concurrentmap
+
+import (
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	sharded_test_shards = 16
+	sharded_bench_keys  = 1 << 16
+)
+
+func TestShardedCMap(t *testing.T) {
+	m := NewShardedCIntKeyMap(sharded_test_shards)
+	threadCount := runtime.NumCPU() + 1
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for i := th; i < keys_to_test; i += threadCount {
+				m.Put(i, i)
+			}
+			for i := th; i < keys_to_test; i += 2 * threadCount {
+				if v := m.Del(i); v != i {
+					t.Errorf("expected deleted value %d, but found %v", i, v)
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	for i := 0; i < keys_to_test; i++ {
+		deleted := i % (2 * threadCount) < threadCount
+		if v := m.Get(i); (deleted && v != nil) || (!deleted && v != i) {
+			t.Errorf("unexpected value %v for key %d", v, i)
+		}
+	}
+	count := 0
+	m.Range(func(key int, value Data) bool {
+		count++
+		return true
+	})
+	if count != m.Len() {
+		t.Errorf("Len %d must be equal to amount of present keys %d", m.Len(), count)
+	}
+}
+
+//longestProbeBound is expected length of the longest cluster of random hash codes in the table of linear probing,
+//ln(capacity) / (a - 1 - ln(a)) for load factor a. Table is filled up to the load factor before grow,
+//so the bound holds for any amount of entries: 239 slots for 8192 slots and load factor 0.75
+func longestProbeBound(capacity int, loadFactor float32) int {
+	a := float64(loadFactor)
+	return int(math.Log(float64(capacity)) / (a - 1 - math.Log(a)))
+}
+
+//every shard grows by itself and keys are spread evenly, even sequential keys
+func TestShardedCMapPerShardResize(t *testing.T) {
+	m := NewShardedCStrKeyMap(10, WithInitialCapacity(1600))
+	stats := m.Stats()
+	if len(stats) != sharded_test_shards || stats[0].Capacity != capacityFor(100, default_load_factor) {
+		t.Fatalf("16 shards with initial capacity for 100 entries expected: %+v", stats[0])
+	}
+
+	for i := 0; i < keys_to_test; i++ {
+		m.Put("key#"+strconv.Itoa(i), i)
+	}
+	if m.Len() != keys_to_test {
+		t.Errorf("expected len %d, but found %d", keys_to_test, m.Len())
+	}
+	for _, shard := range m.Stats() {
+		if shard.Rehashes == 0 || shard.Len < keys_to_test/sharded_test_shards/2 || shard.Len > keys_to_test/sharded_test_shards*2 {
+			t.Errorf("keys must be spread over shards: %+v", shard)
+		}
+		if shard.LongestProbe > longestProbeBound(shard.Capacity, default_load_factor) {
+			t.Errorf("keys must be spread over the table of the shard: %+v", shard)
+		}
+	}
+
+	ints := NewShardedCIntKeyMap(sharded_test_shards)
+	for i := 0; i < keys_to_test; i++ {
+		ints.Put(i, i)
+	}
+	for _, shard := range ints.Stats() {
+		if shard.Len < keys_to_test/sharded_test_shards/2 || shard.LongestProbe > 64 {
+			t.Errorf("sequential keys must be spread over shards: %+v", shard)
+		}
+	}
+
+	expectPanic(t, "zero shards", func() { NewShardedCIntKeyMap(0) })
+}
+
+//benchmarkMixedLoad runs b.N operations by goroutines, every 4th operation is write
+func benchmarkMixedLoad(b *testing.B, get func(key int) Data, put func(key int, value Data) Data, del func(key int) Data) {
+	for i := 0; i < sharded_bench_keys; i++ {
+		put(i, i)
+	}
+
+	for _, threadCount := range []int{1, 2, 4, 8, 16, 32, 64} {
+		b.Run(strconv.Itoa(threadCount), func(b *testing.B) {
+			wg := sync.WaitGroup{}
+			b.ResetTimer()
+			for th := 0; th < threadCount; th++ {
+				wg.Add(1)
+				go func(th int) {
+					defer wg.Done()
+					key := th * 7919
+					for n := th; n < b.N; n += threadCount {
+						key = (key + 1) & (sharded_bench_keys - 1)
+						switch n & 7 {
+						case 0:
+							put(key, n)
+						case 4:
+							del(key)
+						default:
+							get(key)
+						}
+					}
+				}(th)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkCIntKeyMap_MixedLoad(b *testing.B) {
+	m := NewCIntKeyMap()
+	benchmarkMixedLoad(b, m.Get, m.Put, m.Del)
+}
+
+func BenchmarkShardedCMap_MixedLoad(b *testing.B) {
+	m := NewShardedCIntKeyMap(sharded_test_shards)
+	benchmarkMixedLoad(b, m.Get, m.Put, m.Del)
+}
@@ -0,0 +1,173 @@
+package concurrentmap
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"sync"
+)
+
+//Map is the set of sync.Map methods, it is implemented by *sync.Map and *SyncMap,
+//so code may switch implementations behind it
+type Map interface {
+	Load(key any) (value any, ok bool)
+	Store(key, value any)
+	LoadOrStore(key, value any) (actual any, loaded bool)
+	LoadAndDelete(key any) (value any, loaded bool)
+	Delete(key any)
+	Range(f func(key, value any) bool)
+}
+
+var (
+	_ Map = (*sync.Map)(nil)
+	_ Map = (*SyncMap)(nil)
+)
+
+//SyncMap is drop-in replacement of sync.Map backed by ConcurrentMap.
+//Zero value is empty map ready to use, SyncMap must not be copied after first use like sync.Map.
+//Keys are hashed by AnyHasher, Range is weakly consistent, see Iterator
+type SyncMap struct {
+	once sync.Once
+	m    ConcurrentMap[any, any]
+}
+
+//NewSyncMap creates SyncMap with options of the backing map
+func NewSyncMap(opts ...Option) *SyncMap {
+	s := &SyncMap{}
+	s.once.Do(func() {
+		s.m.init(NewAnyHasher(), opts)
+	})
+	return s
+}
+
+func (s *SyncMap) cmap() *ConcurrentMap[any, any] {
+	s.once.Do(func() {
+		s.m.init(NewAnyHasher(), nil)
+	})
+	return &s.m
+}
+
+func (s *SyncMap) Load(key any) (value any, ok bool) {
+	return s.cmap().Load(key)
+}
+
+func (s *SyncMap) Store(key, value any) {
+	s.cmap().Put(key, value)
+}
+
+func (s *SyncMap) LoadOrStore(key, value any) (actual any, loaded bool) {
+	return s.cmap().LoadOrStore(key, value)
+}
+
+func (s *SyncMap) LoadAndDelete(key any) (value any, loaded bool) {
+	return s.cmap().LoadAndDelete(key)
+}
+
+func (s *SyncMap) Delete(key any) {
+	s.cmap().Del(key)
+}
+
+func (s *SyncMap) Range(f func(key, value any) bool) {
+	s.cmap().Range(f)
+}
+
+//Len returns amount of entries, sync.Map has no such method
+func (s *SyncMap) Len() int {
+	return s.cmap().Len()
+}
+
+//--------------------------------------------------------------------------------------
+// hasher of any comparable key
+//--------------------------------------------------------------------------------------
+//AnyHasher hashes any comparable key: equal keys (by == of interfaces) have equal hash codes.
+//Common key types are hashed directly, others are walked by reflection, pointers and channels
+//are hashed by address. Panics on not comparable key, like map and sync.Map do
+type AnyHasher struct {
+	seed maphash.Seed
+}
+
+//NewAnyHasher creates hasher with new random seed
+func NewAnyHasher() AnyHasher {
+	return AnyHasher{seed: maphash.MakeSeed()}
+}
+
+func (h AnyHasher) Hash(key any) uint64 {
+	switch k := key.(type) {
+	case int:
+		return uint64(k)
+	case int64:
+		return uint64(k)
+	case int32:
+		return uint64(k)
+	case uint64:
+		return k
+	case uint32:
+		return uint64(k)
+	case string:
+		return maphash.String(h.seed, k)
+	}
+
+	var mh maphash.Hash
+	mh.SetSeed(h.seed)
+	hashValue(&mh, reflect.ValueOf(key))
+	return mh.Sum64()
+}
+
+func hashUint64(mh *maphash.Hash, v uint64) {
+	var buf [8]byte
+	for i := range buf {
+		buf[i] = byte(v >> (8 * i))
+	}
+	mh.Write(buf[:])
+}
+
+func hashFloat(mh *maphash.Hash, f float64) {
+	if f == 0 {
+		f = 0 //-0 == +0, NaN is never equal, so any hash code is fine for it
+	}
+	hashUint64(mh, math.Float64bits(f))
+}
+
+func hashValue(mh *maphash.Hash, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		mh.WriteByte(0) //nil interface
+	case reflect.Bool:
+		if v.Bool() {
+			mh.WriteByte(1)
+		} else {
+			mh.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		hashUint64(mh, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		hashUint64(mh, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		hashFloat(mh, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		hashFloat(mh, real(c))
+		hashFloat(mh, imag(c))
+	case reflect.String:
+		mh.WriteString(v.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		hashUint64(mh, uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			mh.WriteByte(0)
+		} else {
+			hashValue(mh, v.Elem())
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			hashValue(mh, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			hashValue(mh, v.Field(i))
+		}
+	default:
+		panic(fmt.Sprintf("key of type %s is not comparable", v.Type()))
+	}
+}
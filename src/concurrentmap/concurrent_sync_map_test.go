@@ -0,0 +1,92 @@
+package concurrentmap
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+type tstSyncMapKey struct {
+	name string
+	id   int
+	ptr  *int
+}
+
+//every operation of SyncMap must return the same result as sync.Map
+func TestSyncMapLikeSyncMap(t *testing.T) {
+	var expected, actual Map = &sync.Map{}, &SyncMap{} //zero value is ready to use
+	p1, p2 := new(int), new(int)
+	keys := []any{nil, 0, 1, int64(1), uint32(1), "1", "", 1.5, math.Copysign(0, -1), 0.0, true,
+		tstSyncMapKey{"a", 1, p1}, tstSyncMapKey{"a", 1, p2}, p1, p2, [2]string{"a", "b"}, complex(1, 2)}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		key := keys[rnd.Intn(len(keys))]
+		value := any(i)
+		if i%10 == 0 {
+			value = nil
+		}
+		switch rnd.Intn(5) {
+		case 0:
+			expected.Store(key, value)
+			actual.Store(key, value)
+		case 1:
+			ev, eok := expected.Load(key)
+			av, aok := actual.Load(key)
+			if ev != av || eok != aok {
+				t.Fatalf("Load(%v): expected %v, %t, but found %v, %t", key, ev, eok, av, aok)
+			}
+		case 2:
+			ev, eok := expected.LoadOrStore(key, value)
+			av, aok := actual.LoadOrStore(key, value)
+			if ev != av || eok != aok {
+				t.Fatalf("LoadOrStore(%v): expected %v, %t, but found %v, %t", key, ev, eok, av, aok)
+			}
+		case 3:
+			ev, eok := expected.LoadAndDelete(key)
+			av, aok := actual.LoadAndDelete(key)
+			if ev != av || eok != aok {
+				t.Fatalf("LoadAndDelete(%v): expected %v, %t, but found %v, %t", key, ev, eok, av, aok)
+			}
+		case 4:
+			expected.Delete(key)
+			actual.Delete(key)
+		}
+	}
+
+	entries := make(map[any]any)
+	expected.Range(func(key, value any) bool {
+		entries[key] = value
+		return true
+	})
+	actual.Range(func(key, value any) bool {
+		if v, found := entries[key]; !found || v != value {
+			t.Errorf("unexpected entry %v: %v", key, value)
+		}
+		delete(entries, key)
+		return true
+	})
+	if len(entries) != 0 {
+		t.Errorf("entries are not visited by Range: %v", entries)
+	}
+
+	expectPanic(t, "not comparable key", func() { actual.Store([]int{1}, 1) })
+}
+
+func TestAnyHasher(t *testing.T) {
+	h := NewAnyHasher()
+	if h.Hash(0.0) != h.Hash(math.Copysign(0, -1)) {
+		t.Errorf("-0 and +0 are equal keys")
+	}
+	if h.Hash(tstSyncMapKey{"a", 1, nil}) != h.Hash(tstSyncMapKey{"a", 1, nil}) {
+		t.Errorf("equal structs must have equal hash codes")
+	}
+	var i any = 1
+	if h.Hash([1]any{i}) != h.Hash([1]any{1}) {
+		t.Errorf("equal arrays of interfaces must have equal hash codes")
+	}
+	if NewSyncMap(WithInitialCapacity(1000)).cmap().Stats().Capacity < 1000 {
+		t.Errorf("options must be applied to the backing map")
+	}
+}
@@ -1,6 +1,7 @@
 package lhmap
 
 import (
+	"fmt"
 	"unsafe"
 )
 
@@ -23,6 +24,10 @@ const (
 	//good value from java framework
 	defaultLoadFactor = float32(0.75)
 
+	//insert rehashes the table in place if deleted slots occupy more than 1/tombstonesRate of capacity,
+	//deleted slots are not reused by other keys and make probe chains longer
+	tombstonesRate = 4
+
 	//used to calculate hash from key, by the way key ^ (key >> hashShift)
 	//intended to involve high and low bits to the hash calculation
 	//this value is used in java8
@@ -41,10 +46,12 @@ type (
 		flagSize            int
 		data                []byte
 		liveItemsCount      int
-		allocatedItemsCount int
+		allocatedItemsCount int //live and deleted slots
+		deletedItemsCount   int
 		generation          flagType
 		keyCtr              func() KeyType
 		tmpKey              KeyType
+		file                *mapFile //not nil if data is mapped from the file, see lhmap_file.go
 	}
 
 	KeyType interface {
@@ -88,7 +95,14 @@ func capacityToPowerOf2(capacity int) int {
 }
 
 func NewLhMap(keyCtr func() KeyType, dataSize int, capacity int) *LhMap {
-	capacity = capacityToPowerOf2(capacity)
+	s := newLhMap(keyCtr, dataSize, capacityToPowerOf2(capacity))
+	size := s.capacity * s.itemSize
+	s.data = make([]byte, size, size)
+	return s
+}
+
+//newLhMap creates map without data
+func newLhMap(keyCtr func() KeyType, dataSize int, capacity int) *LhMap {
 	tmpKey := keyCtr()
 
 	s := &LhMap{
@@ -111,9 +125,6 @@ func NewLhMap(keyCtr func() KeyType, dataSize int, capacity int) *LhMap {
 
 	s.headerSize = s.keySize + s.flagSize
 	s.itemSize = s.headerSize + s.dataSize
-	size := s.capacity * s.itemSize
-	s.data = make([]byte, size, size)
-
 	return s
 }
 
@@ -140,11 +151,19 @@ func (s *LhMap) setFlag(index int, f flagType) {
 	*(*flagType)(unsafe.Pointer(&s.data[s.shift(index)+s.keySize])) = f
 }
 
+//isEmptySlot returns true if slot is never used or used by previous generation, deleted slot of actual generation is not empty
 func (s *LhMap) isEmptySlot(index int) bool {
 	f := s.flag(index)
-	deleted := f&deletedFlag > 0
 	generation := f & generationMask
-	return generation != s.generation && !deleted
+	return generation != s.generation
+}
+
+func (s *LhMap) isDeletedSlot(index int) bool {
+	return !s.isEmptySlot(index) && s.flag(index)&deletedFlag > 0
+}
+
+func (s *LhMap) isLiveSlot(index int) bool {
+	return !s.isEmptySlot(index) && s.flag(index)&deletedFlag == 0
 }
 
 func (s *LhMap) pKey(index int) unsafe.Pointer {
@@ -156,15 +175,41 @@ func (s *LhMap) pData(index int) unsafe.Pointer {
 }
 
 func (s *LhMap) Clear() {
+	s.checkWritable()
 	s.generation = (s.generation + 1) & generationMask
 	s.liveItemsCount = 0
 	s.allocatedItemsCount = 0
+	s.deletedItemsCount = 0
 
 	//if wrap around - make new slice and start with gen == 1 again
 	if s.generation <= 0 {
-		size := s.capacity * s.itemSize
-		s.data = make([]byte, size, size)
+		s.data = s.newTable(s.capacity)
 		s.generation = 1
+		s.commitTable()
+	} else if s.file != nil {
+		s.writeHeader(true) //new generation must be stored, otherwise cleared slots are live again after restart
+	}
+}
+
+//newTable allocates zeroed slots, they are mapped from the new file if the map is stored in the file
+func (s *LhMap) newTable(capacity int) []byte {
+	if s.file != nil {
+		data, err := s.file.newTable(capacity, s.itemSize)
+		if err != nil {
+			panic(fmt.Sprintf("can't create table of map file: %v", err))
+		}
+		return data
+	}
+	size := capacity * s.itemSize
+	return make([]byte, size, size)
+}
+
+//commitTable replaces the file of the map by the file of the new table, see newTable
+func (s *LhMap) commitTable() {
+	if s.file != nil {
+		if err := s.file.commitTable(s); err != nil {
+			panic(fmt.Sprintf("can't replace map file: %v", err))
+		}
 	}
 }
 
@@ -192,70 +237,156 @@ func (s *LhMap) findSlotByLinearProbing(key KeyType) (int, bool) {
 	return -1, false //nothing found, table is full
 }
 
-func (s *LhMap) ensureCapacity(newCount int) bool {
+//ensureCapacity rehashes the table if there is no free slot for newCount items, ok is false if newCount is too big
+func (s *LhMap) ensureCapacity(newCount int) (ok bool, rehashed bool) {
 	if newCount > maxCapacity {
-		return false
+		return false, false
 	}
-	if newCount <= s.threshold {
-		return true //already have enough capacity
+	if newCount <= s.threshold && s.deletedItemsCount <= s.capacity/tombstonesRate {
+		return true, false //already have enough capacity
+	}
+	if newCount <= s.threshold || s.liveItemsCount+1 <= s.threshold/2 {
+		//free space is taken by deleted slots, drop them
+		s.rehash(s.capacity)
+		return true, true
 	}
 	//enlarge size
 	s.rehash(s.capacity << 1)
-	return true
+	return true, true
 }
 
+//rehash copies live slots to the new table, deleted slots are dropped
 func (s *LhMap) rehash(newCapacity int) {
+	s.checkWritable()
 	oldS := &LhMap{}
 	*oldS = *s
 
-	newSize := newCapacity * s.itemSize
+	s.data = s.newTable(newCapacity)
 	s.capacity = newCapacity
-	s.data = make([]byte, newSize, newSize)
 	s.threshold = calcThreshold(newCapacity, s.loadFactor)
+	s.allocatedItemsCount = s.liveItemsCount
+	s.deletedItemsCount = 0
 
 	for i := 0; i < oldS.capacity; i++ {
 		oldShift := oldS.shift(i)
 
-		if !oldS.isEmptySlot(i) {
+		if oldS.isLiveSlot(i) {
 			mK := oldS.key(i)
 			idx, _ := s.findSlotByLinearProbing(mK)
 			shift := s.shift(idx)
 			//copy memory
-			for j := 0; j < s.itemSize; j++ {
-				s.data[shift+j] = oldS.data[oldShift+j]
-			}
+			copy(s.data[shift:shift+s.itemSize], oldS.data[oldShift:oldShift+s.itemSize])
 		}
 	}
+	s.commitTable()
 }
 
+//Compact rehashes the table in place if there are deleted slots, probe chains become shorter
+func (s *LhMap) Compact() {
+	if s.deletedItemsCount > 0 {
+		s.rehash(s.capacity)
+	}
+}
+
+//ShrinkToFit rehashes the table to the smallest capacity which keeps live items under load factor,
+//deleted slots are dropped
+func (s *LhMap) ShrinkToFit() {
+	newCapacity := initialCapacity
+	for calcThreshold(newCapacity, s.loadFactor) < s.liveItemsCount {
+		newCapacity <<= 1
+	}
+	if newCapacity < s.capacity || s.deletedItemsCount > 0 {
+		s.rehash(newCapacity)
+	}
+}
+
+//Cap returns amount of slots
+func (s *LhMap) Cap() int {
+	return s.capacity
+}
+
+//findOrInsertSlot returns slot of the key and true if key is present, or free slot for the key.
+//Table is rehashed only for absent key, so Put of present key keeps values in their slots
 func (s *LhMap) findOrInsertSlot(key KeyType) (int, bool) {
-	if !s.ensureCapacity(s.allocatedItemsCount + 1) {
+	s.checkWritable()
+	i, found := s.findSlotByLinearProbing(key)
+	if found {
+		return i, true
+	}
+	ok, rehashed := s.ensureCapacity(s.allocatedItemsCount + 1)
+	if !ok {
 		panic("no more capacity")
 	}
-	i, found := s.findSlotByLinearProbing(key)
+	if rehashed {
+		i, found = s.findSlotByLinearProbing(key)
+	}
 	if i < 0 {
 		panic("internal error. shouldn't happens, ensureCapacity should provide empty slots")
 	}
 	return i, found
 }
 
-func (s *LhMap) Put(key KeyType, value MapValue) {
-	if value == nil {
-		panic("nil value is not allowed")
-	}
-
+//insertSlot returns slot of the key, key is inserted if it is absent
+func (s *LhMap) insertSlot(key KeyType) (int, bool) {
 	index, found := s.findOrInsertSlot(key)
 	if !found {
+		if s.isDeletedSlot(index) {
+			s.deletedItemsCount-- //deleted slot of the key is used again
+		} else {
+			s.allocatedItemsCount++
+		}
 		s.liveItemsCount++
-		s.allocatedItemsCount++
 		s.setKey(index, key)
 		s.setFlag(index, s.generation & ^deletedFlag)
 	}
+	return index, found
+}
 
+func (s *LhMap) Put(key KeyType, value MapValue) {
+	if value == nil {
+		panic("nil value is not allowed")
+	}
+
+	index, _ := s.insertSlot(key)
 	p := s.pData(index)
 	value.WriteTo(p)
 }
 
+//UpsertAndReturnPointer returns pointer to the value of the key and true if key is new, value of new key is zeroed.
+//Pointer is valid until the table is rehashed: by insert of absent key (Put, Upsert or Compute),
+//Compact, ShrinkToFit, Clear or Close. Update of present key and Del don't move values
+func (s *LhMap) UpsertAndReturnPointer(key KeyType) (unsafe.Pointer, bool) {
+	index, found := s.insertSlot(key)
+	if !found {
+		s.zeroData(index) //slot may keep value of deleted key or of previous generation
+	}
+	return s.pData(index), !found
+}
+
+//GetPointer returns pointer to the value of the key or nil if key is absent, see UpsertAndReturnPointer
+func (s *LhMap) GetPointer(key KeyType) unsafe.Pointer {
+	index, found := s.findSlotByLinearProbing(key)
+	if !found {
+		return nil
+	}
+	return s.pData(index)
+}
+
+//Compute calls fn with pointer to the value of the key, key is inserted with zeroed value if it is absent,
+//so value is updated with single probe. Pointer must not be kept after fn returns
+func (s *LhMap) Compute(key KeyType, fn func(p unsafe.Pointer, existed bool)) {
+	p, isNew := s.UpsertAndReturnPointer(key)
+	fn(p, !isNew)
+}
+
+func (s *LhMap) zeroData(index int) {
+	shift := s.shift(index) + s.headerSize
+	data := s.data[shift : shift+s.dataSize]
+	for i := range data {
+		data[i] = 0
+	}
+}
+
 func (s *LhMap) Get(key KeyType, value MapValue) bool {
 	index, found := s.findSlotByLinearProbing(key)
 	if !found {
@@ -272,6 +403,7 @@ func (s *LhMap) Get(key KeyType, value MapValue) bool {
 }
 
 func (s *LhMap) Del(key KeyType) bool {
+	s.checkWritable()
 	index, found := s.findSlotByLinearProbing(key)
 	if !found {
 		return false
@@ -279,6 +411,7 @@ func (s *LhMap) Del(key KeyType) bool {
 
 	s.setFlag(index, s.flag(index)|deletedFlag)
 	s.liveItemsCount--
+	s.deletedItemsCount++
 	return true
 }
 
@@ -288,7 +421,7 @@ func (s *LhMap) Len() int {
 
 func (s *LhMap) VisitAll(visitor Visitor) {
 	for i := 0; i < s.capacity; i++ {
-		if !s.isEmptySlot(i) {
+		if s.isLiveSlot(i) {
 			k := s.pKey(i)
 			p := s.pData(i)
 			visitor(i, k, p)
@@ -304,7 +437,7 @@ func (s *LhMap) Visit(start, count int, visitor Visitor) (next int) {
 	v := 0
 	i := start
 	for ; i < s.capacity && v < count; i++ {
-		if !s.isEmptySlot(i) {
+		if s.isLiveSlot(i) {
 			k := s.pKey(i)
 			p := s.pData(i)
 			visitor(i, k, p)
@@ -3,6 +3,7 @@ package lhmap
 import (
 	"math/rand"
 	"testing"
+	"unsafe"
 )
 
 type (
@@ -77,6 +78,36 @@ func BenchmarkLhMapVsMap(b *testing.B) {
 		}
 	})
 
+	b.Run("TypedLhMap", func(b *testing.B) {
+		b.StopTimer()
+		m := NewTypedLhMap[tstKeyR, tstValueR](len(bs))
+		for _, d := range bs {
+			m.Put(d.key, d.value)
+		}
+		b.StartTimer()
+
+		for i := 0; i < b.N; i++ {
+			//check positive keys
+			blackHole = 0
+			for _, d := range bs {
+				if v, f := m.Get(d.key); f {
+					blackHole = blackHole + float64(v)
+				}
+			}
+			if blackHole != cs {
+				b.Error("Upps, wrong data into map")
+			}
+
+			//negative keys
+			for _, d := range bs {
+				if v, f := m.Get(d.nKey); f {
+					blackHole = blackHole + float64(v)
+				}
+			}
+
+		}
+	})
+
 	b.Run("Map", func(b *testing.B) {
 		b.StopTimer()
 		m := make(map[tstKeyR]tstValueR, len(bs))
@@ -107,3 +138,33 @@ func BenchmarkLhMapVsMap(b *testing.B) {
 		}
 	})
 }
+
+//aggregation by key: Get and Put copy value and probe twice, Compute updates value in the slot
+func BenchmarkLhMapAggregation(b *testing.B) {
+	keys := make([]tstKeyR, 1000)
+	for i := range keys {
+		keys[i] = tstKeyR{a: rand.Uint32(), b: rand.Uint32()}
+	}
+
+	b.Run("GetPut", func(b *testing.B) {
+		m := NewLhMap(func() KeyType { return &tstKeyR{} }, tstValueRExample.Size(), len(keys))
+		var v tstValueR
+		for i := 0; i < b.N; i++ {
+			k := &keys[i%len(keys)]
+			if !m.Get(k, &v) {
+				v = 0
+			}
+			v++
+			m.Put(k, &v)
+		}
+	})
+
+	b.Run("Compute", func(b *testing.B) {
+		m := NewLhMap(func() KeyType { return &tstKeyR{} }, tstValueRExample.Size(), len(keys))
+		for i := 0; i < b.N; i++ {
+			m.Compute(&keys[i%len(keys)], func(p unsafe.Pointer, existed bool) {
+				*(*tstValueR)(p)++
+			})
+		}
+	})
+}
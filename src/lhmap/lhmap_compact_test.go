@@ -0,0 +1,143 @@
+package lhmap
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+)
+
+func newTstLhMap(capacity int) *LhMap {
+	return NewLhMap(func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), capacity)
+}
+
+func tstKey(i int) *tstKeyA {
+	return &tstKeyA{a: uint32(i), b: uint32(i * 7), c: uint32(i * 13)}
+}
+
+func checkKeys(t *testing.T, m *LhMap, from, to int) {
+	b := tstStructA{}
+	for i := from; i < to; i++ {
+		if !m.Get(tstKey(i), &b) || b.x != int32(i) {
+			t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v]", i, b.x))
+		}
+	}
+}
+
+//table of delete-heavy map is rehashed in place, it doesn't grow
+func TestDeleteHeavyDoesntGrow(t *testing.T) {
+	m := newTstLhMap(10)
+	capacity := m.Cap()
+	for i := 0; i < 100000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+		if i >= 10 {
+			m.Del(tstKey(i - 10))
+		}
+		if m.deletedItemsCount > capacity/tombstonesRate+1 {
+			t.Fatal(fmt.Sprintf("too many deleted slots: %v", m.deletedItemsCount))
+		}
+	}
+	if m.Cap() != capacity || m.Len() != 10 {
+		t.Error(fmt.Sprintf("capacity %v and len 10 expected, actual: %v, %v", capacity, m.Cap(), m.Len()))
+	}
+	checkKeys(t, m, 100000-10, 100000)
+}
+
+func TestRehashDropsTombstones(t *testing.T) {
+	m := newTstLhMap(10)
+	for i := 0; i < 1000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	//less than 1/tombstonesRate of slots are deleted
+	for i := 0; i < 400; i++ {
+		m.Del(tstKey(i))
+	}
+	//deleted key is inserted again into its own slot
+	m.Put(tstKey(0), &tstStructA{x: 0})
+	if m.deletedItemsCount != 399 || m.allocatedItemsCount != 1000 {
+		t.Error(fmt.Sprintf("deleted slot must be used again: %v deleted, %v allocated", m.deletedItemsCount, m.allocatedItemsCount))
+	}
+
+	m.rehash(m.Cap() << 1)
+	if m.deletedItemsCount != 0 || m.allocatedItemsCount != m.Len() || m.Len() != 601 {
+		t.Error(fmt.Sprintf("deleted slots must be dropped: %v deleted, %v allocated, %v live", m.deletedItemsCount, m.allocatedItemsCount, m.Len()))
+	}
+	checkKeys(t, m, 400, 1000)
+	checkKeys(t, m, 0, 1)
+
+	//too many deleted slots, insert rehashes the table in place
+	capacity := m.Cap()
+	for i := 1000; i < 3000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	for i := 1000; i < 3000; i++ {
+		m.Del(tstKey(i))
+	}
+	m.Put(tstKey(1), &tstStructA{x: 1})
+	if m.deletedItemsCount != 0 || m.Cap() != capacity || m.Len() != 602 {
+		t.Error(fmt.Sprintf("table must be rehashed in place: %v deleted, %v capacity, %v live", m.deletedItemsCount, m.Cap(), m.Len()))
+	}
+	checkKeys(t, m, 0, 2)
+	checkKeys(t, m, 400, 1000)
+}
+
+func TestCompactAndShrinkToFit(t *testing.T) {
+	m := newTstLhMap(10)
+	for i := 0; i < 10000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	for i := 0; i < 10000; i += 2 {
+		m.Del(tstKey(i))
+	}
+	capacity := m.Cap()
+
+	m.Compact()
+	if m.Cap() != capacity || m.deletedItemsCount != 0 || m.allocatedItemsCount != 5000 {
+		t.Error(fmt.Sprintf("table must be rehashed in place: %v capacity, %v deleted", m.Cap(), m.deletedItemsCount))
+	}
+	visited := 0
+	m.VisitAll(func(idx int, key, p unsafe.Pointer) {
+		visited++
+	})
+	if visited != 5000 {
+		t.Error(fmt.Sprintf("5000 live slots expected, visited: %v", visited))
+	}
+
+	for i := 1; i < 10000-20; i += 2 {
+		m.Del(tstKey(i))
+	}
+	m.ShrinkToFit()
+	if m.Cap() != 16 || len(m.data) != 16*m.itemSize || m.Len() != 10 {
+		t.Error(fmt.Sprintf("table must be shrunk to 16 slots, actual: %v slots, %v len", m.Cap(), m.Len()))
+	}
+	for i := 10000 - 20 + 1; i < 10000; i += 2 {
+		checkKeys(t, m, i, i+1)
+	}
+
+	//map grows again after shrink
+	for i := 0; i < 1000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	checkKeys(t, m, 0, 1000)
+}
+
+//deleted slots of previous generation are empty
+func TestClearDropsTombstones(t *testing.T) {
+	m := newTstLhMap(10)
+	for i := 0; i < 100; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+		m.Del(tstKey(i))
+	}
+	m.Clear()
+	for i := 0; i < m.Cap(); i++ {
+		if !m.isEmptySlot(i) {
+			t.Error(fmt.Sprintf("slot %v must be empty after Clear", i))
+		}
+	}
+	for i := 0; i < 100; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	if m.deletedItemsCount != 0 || m.allocatedItemsCount != 100 {
+		t.Error(fmt.Sprintf("%v deleted, %v allocated slots after Clear", m.deletedItemsCount, m.allocatedItemsCount))
+	}
+	checkKeys(t, m, 0, 100)
+}
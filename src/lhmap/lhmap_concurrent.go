@@ -0,0 +1,136 @@
+package lhmap
+
+import (
+	"math/bits"
+	"sync"
+	"unsafe"
+)
+
+const maxSegments = 1 << 16
+
+/*
+   ConcurrentLhMap splits keys between segments, every segment is LhMap protected by its own RWMutex.
+   Get doesn't change LhMap (tmpKey is used by rehash only), so Gets of the segment run concurrently under
+   read lock, Put and Del take write lock of the segment. Segment is chosen by high bits of the mixed hash code,
+   LhMap of the segment chooses slot by low bits, so keys of the segment are spread over all its slots.
+   Keys and values passed to the map are used by the calling goroutine only, they must not be shared.
+*/
+type (
+	ConcurrentLhMap struct {
+		segmentBits uint
+		segments    []lhSegment
+	}
+
+	lhSegment struct {
+		lock sync.RWMutex
+		m    *LhMap
+		_    [32]byte //segments don't share cache line
+	}
+)
+
+//NewConcurrentLhMap creates map of segments (rounded up to power of 2), capacity is divided between segments
+func NewConcurrentLhMap(keyCtr func() KeyType, dataSize int, capacity int, segments int) *ConcurrentLhMap {
+	if segments < 1 || segments > maxSegments {
+		panic("amount of segments is out of range")
+	}
+	segmentBits := uint(bits.Len(uint(segments - 1)))
+	segments = 1 << segmentBits
+
+	s := &ConcurrentLhMap{
+		segmentBits: segmentBits,
+		segments:    make([]lhSegment, segments),
+	}
+	for i := range s.segments {
+		s.segments[i].m = NewLhMap(keyCtr, dataSize, (capacity+segments-1)/segments)
+	}
+	return s
+}
+
+func (s *ConcurrentLhMap) segment(key KeyType) *lhSegment {
+	if s.segmentBits == 0 {
+		return &s.segments[0]
+	}
+	return &s.segments[mix64(uint64(key.Hash()))>>(64-s.segmentBits)]
+}
+
+func (s *ConcurrentLhMap) Put(key KeyType, value MapValue) {
+	seg := s.segment(key)
+	seg.lock.Lock()
+	defer seg.lock.Unlock()
+	seg.m.Put(key, value)
+}
+
+func (s *ConcurrentLhMap) Get(key KeyType, value MapValue) bool {
+	seg := s.segment(key)
+	seg.lock.RLock()
+	defer seg.lock.RUnlock()
+	return seg.m.Get(key, value)
+}
+
+//Compute calls fn with pointer to the value of the key under write lock of the segment, see LhMap.Compute
+func (s *ConcurrentLhMap) Compute(key KeyType, fn func(p unsafe.Pointer, existed bool)) {
+	seg := s.segment(key)
+	seg.lock.Lock()
+	defer seg.lock.Unlock()
+	seg.m.Compute(key, fn)
+}
+
+func (s *ConcurrentLhMap) Del(key KeyType) bool {
+	seg := s.segment(key)
+	seg.lock.Lock()
+	defer seg.lock.Unlock()
+	return seg.m.Del(key)
+}
+
+//Len returns sum of segments, segments are counted one by one
+func (s *ConcurrentLhMap) Len() int {
+	count := 0
+	for i := range s.segments {
+		seg := &s.segments[i]
+		seg.lock.RLock()
+		count += seg.m.Len()
+		seg.lock.RUnlock()
+	}
+	return count
+}
+
+//Clear clears segments one by one
+func (s *ConcurrentLhMap) Clear() {
+	for i := range s.segments {
+		seg := &s.segments[i]
+		seg.lock.Lock()
+		seg.m.Clear()
+		seg.lock.Unlock()
+	}
+}
+
+//Compact drops deleted slots of segments one by one, see LhMap.Compact
+func (s *ConcurrentLhMap) Compact() {
+	for i := range s.segments {
+		seg := &s.segments[i]
+		seg.lock.Lock()
+		seg.m.Compact()
+		seg.lock.Unlock()
+	}
+}
+
+//ShrinkToFit decreases capacity of segments one by one, see LhMap.ShrinkToFit
+func (s *ConcurrentLhMap) ShrinkToFit() {
+	for i := range s.segments {
+		seg := &s.segments[i]
+		seg.lock.Lock()
+		seg.m.ShrinkToFit()
+		seg.lock.Unlock()
+	}
+}
+
+//VisitAll visits segments one by one under read lock of the segment, visitor must not change the map,
+//idx is index of the slot in its segment
+func (s *ConcurrentLhMap) VisitAll(visitor Visitor) {
+	for i := range s.segments {
+		seg := &s.segments[i]
+		seg.lock.RLock()
+		seg.m.VisitAll(visitor)
+		seg.lock.RUnlock()
+	}
+}
@@ -0,0 +1,115 @@
+package lhmap
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+const concurrentTestKeys = 20000
+
+//value of the key is derived from the key, reader checks what value is not torn
+func tstValueOf(k *tstKeyA, version int64) tstStructA {
+	return tstStructA{t: version, x: int32(k.a ^ k.b), f64: float64(k.c)}
+}
+
+func TestConcurrentLhMap(t *testing.T) {
+	m := NewConcurrentLhMap(func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10, 16)
+	threadCount := runtime.NumCPU() + 2
+
+	keys := make([][]tstKeyA, threadCount)
+	for th := range keys {
+		for i := 0; i < concurrentTestKeys/threadCount; i++ {
+			keys[th] = append(keys[th], tstKeyA{a: rand.Uint32(), b: rand.Uint32(), c: uint32(th)})
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		//writer of own keys
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			for version := int64(1); version <= 3; version++ {
+				for i := range keys[th] {
+					k := &keys[th][i]
+					v := tstValueOf(k, version)
+					m.Put(k, &v)
+					if version == 3 && i%2 == 0 {
+						m.Del(k)
+					}
+				}
+			}
+		}(th)
+
+		//reader of keys of other writer
+		wg.Add(1)
+		go func(th int) {
+			defer wg.Done()
+			other := keys[(th+1)%threadCount]
+			var v tstStructA
+			for version := 0; version < 3; version++ {
+				for i := range other {
+					k := other[i]
+					if m.Get(&k, &v) {
+						if expected := tstValueOf(&k, v.t); v != expected {
+							t.Error(fmt.Sprintf("torn value of key %v, actual: [%v], expected: [%v]", k, v, expected))
+							return
+						}
+					}
+				}
+			}
+		}(th)
+	}
+	wg.Wait()
+
+	expectedLen := 0
+	var v tstStructA
+	for th := range keys {
+		for i := range keys[th] {
+			k := &keys[th][i]
+			found := m.Get(k, &v)
+			if i%2 == 0 && found {
+				t.Error(fmt.Sprintf("map must't contains key: %v", k))
+			}
+			if i%2 != 0 {
+				expectedLen++
+				if !found || v != tstValueOf(k, 3) {
+					t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v]", k, v))
+				}
+			}
+		}
+	}
+	if m.Len() != expectedLen {
+		t.Error(fmt.Sprintf("Invalid len actual:%v but expectd %v", m.Len(), expectedLen))
+	}
+
+	//keys are spread over segments
+	for i := range m.segments {
+		if l := m.segments[i].m.Len(); l < expectedLen/len(m.segments)/2 {
+			t.Error(fmt.Sprintf("segment %v has %v keys only", i, l))
+		}
+	}
+
+	m.Clear()
+	if m.Len() != 0 {
+		t.Error("map must be empty after Clear")
+	}
+}
+
+func TestConcurrentLhMapSegments(t *testing.T) {
+	m := NewConcurrentLhMap(func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 1000, 5)
+	if len(m.segments) != 8 {
+		t.Error(fmt.Sprintf("segments must be rounded up to 8, actual: %v", len(m.segments)))
+	}
+	single := NewConcurrentLhMap(func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10, 1)
+	k := tstKeyA{a: 1, b: 2, c: 3}
+	v := tstStructA{x: 7}
+	single.Put(&k, &v)
+	if !single.Get(&k, &v) || v.x != 7 || single.Len() != 1 {
+		t.Error("map of single segment must contains key")
+	}
+	expectPanic(t, "zero segments", func() { NewConcurrentLhMap(func() KeyType { return &tstKeyA{} }, 8, 10, 0) })
+}
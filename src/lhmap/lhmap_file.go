@@ -0,0 +1,326 @@
+package lhmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+/*
+   LhMap may keep its slots in the memory mapped file, so the table is opened instantly after restart:
+   slots are not copied, pages of the file are loaded on demand by the first access.
+   File is the header followed by slots, slots have the same layout as data of LhMap in memory:
+     magic "LHMF", version uint32, capacity uint64, itemSize uint32, keySize uint32, dataSize uint32,
+     generation uint16, dirty uint16, liveItemsCount uint64, allocatedItemsCount uint64, deletedItemsCount uint64,
+     loadFactor float32 (bits), all numbers are little endian
+   Header is written by Sync, Close, Clear and rehash, it is marked dirty while the map is opened for write.
+   If process is stopped without Close, counters of the dirty header are recounted by the scan of slots on open.
+   Slots written after the last Sync may be lost by crash of the system, like any data of mapped file.
+   Rehash builds the new table in the new file "<path>.rehash", it is synced and renamed to the path of the map,
+   so the file at the path always has the header matching its slots: crash during rehash leaves the previous table.
+   Keys are found by KeyType.Hash, it must not depend on the process (no random seeds, no pointers).
+*/
+
+const (
+	fileMagic      = "LHMF"
+	fileVersion    = 1
+	fileHeaderSize = 64 //slots are aligned to 8 bytes in the mapping
+	rehashSuffix   = ".rehash"
+)
+
+var (
+	ErrMapFileCorrupted = errors.New("lhmap: map file is corrupted")
+	ErrMapFileMismatch  = errors.New("lhmap: key or value size doesn't match map file")
+	ErrMmapNotSupported = errors.New("lhmap: memory mapped files are not supported on this platform")
+)
+
+//mapFile is the file which keeps header and slots of LhMap
+type mapFile struct {
+	path     string
+	file     *os.File
+	mapping  []byte //header and slots
+	readOnly bool
+
+	//file and mapping of the new table, see newTable
+	next        *os.File
+	nextMapping []byte
+}
+
+//OpenLhMapFile opens map stored in the file or creates new map of capacity if file is empty or absent.
+//Key and value sizes of stored map must match keyCtr and dataSize. Map must be closed by Close
+func OpenLhMapFile(path string, keyCtr func() KeyType, dataSize int, capacity int) (*LhMap, error) {
+	return openLhMapFile(path, keyCtr, dataSize, capacity, false)
+}
+
+//OpenLhMapFileReadOnly opens map stored in the file for read, Put, Del and other updates panic.
+//Value must not be written by pointer returned by GetPointer
+func OpenLhMapFileReadOnly(path string, keyCtr func() KeyType, dataSize int) (*LhMap, error) {
+	return openLhMapFile(path, keyCtr, dataSize, 0, true)
+}
+
+func openLhMapFile(path string, keyCtr func() KeyType, dataSize int, capacity int, readOnly bool) (*LhMap, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	if !readOnly {
+		//table of rehash interrupted by crash, file at the path has the previous table
+		if err := os.Remove(path + rehashSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s, err := mapLhMapFile(path, f, keyCtr, dataSize, capacity, readOnly)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func mapLhMapFile(path string, f *os.File, keyCtr func() KeyType, dataSize int, capacity int, readOnly bool) (*LhMap, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mf := &mapFile{path: path, file: f, readOnly: readOnly}
+
+	if info.Size() == 0 {
+		if readOnly {
+			return nil, fmt.Errorf("%w: file is empty", ErrMapFileCorrupted)
+		}
+		//new map
+		s := newLhMap(keyCtr, dataSize, capacityToPowerOf2(capacity))
+		size, err := fileSize(s.capacity, s.itemSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := mf.mmap(size); err != nil {
+			return nil, err
+		}
+		s.file = mf
+		s.data = mf.mapping[fileHeaderSize:]
+		s.writeHeader(true)
+		return s, nil
+	}
+
+	if info.Size() < fileHeaderSize || info.Size() > math.MaxInt {
+		return nil, fmt.Errorf("%w: wrong file size %d", ErrMapFileCorrupted, info.Size())
+	}
+	if err := mf.mmap(int(info.Size())); err != nil {
+		return nil, err
+	}
+	s, err := readHeader(mf.mapping, keyCtr, dataSize)
+	if err != nil {
+		munmapFile(mf.mapping)
+		return nil, err
+	}
+	s.file = mf
+	s.data = mf.mapping[fileHeaderSize : fileHeaderSize+s.capacity*s.itemSize]
+	if binary.LittleEndian.Uint16(mf.mapping[30:]) != 0 {
+		s.recount() //map was not closed
+	}
+	if !readOnly {
+		s.writeHeader(true)
+	}
+	return s, nil
+}
+
+//readHeader creates map of the header, without data
+func readHeader(mapping []byte, keyCtr func() KeyType, dataSize int) (*LhMap, error) {
+	le := binary.LittleEndian
+	if string(mapping[0:4]) != fileMagic {
+		return nil, fmt.Errorf("%w: wrong magic", ErrMapFileCorrupted)
+	}
+	if version := le.Uint32(mapping[4:]); version != fileVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMapFileCorrupted, version)
+	}
+	capacity := le.Uint64(mapping[8:])
+	if capacity == 0 || capacity > uint64(maxCapacity) || capacity&(capacity-1) != 0 {
+		return nil, fmt.Errorf("%w: wrong capacity %d", ErrMapFileCorrupted, capacity)
+	}
+
+	s := newLhMap(keyCtr, dataSize, int(capacity))
+	itemSize, keySize, storedDataSize := le.Uint32(mapping[16:]), le.Uint32(mapping[20:]), le.Uint32(mapping[24:])
+	if int(itemSize) != s.itemSize || int(keySize) != s.keySize || int(storedDataSize) != s.dataSize {
+		return nil, fmt.Errorf("%w: key %d and value %d bytes are stored, but %d and %d are expected",
+			ErrMapFileMismatch, keySize, storedDataSize, s.keySize, s.dataSize)
+	}
+	//size is compared in uint64, product of capacity and itemSize may overflow int on 32-bit platforms
+	if uint64(s.capacity)*uint64(s.itemSize) > uint64(len(mapping)-fileHeaderSize) {
+		return nil, fmt.Errorf("%w: %d slots don't fit file of %d bytes", ErrMapFileCorrupted, s.capacity, len(mapping))
+	}
+
+	s.generation = flagType(le.Uint16(mapping[28:]))
+	s.liveItemsCount = int(le.Uint64(mapping[32:]))
+	s.allocatedItemsCount = int(le.Uint64(mapping[40:]))
+	s.deletedItemsCount = int(le.Uint64(mapping[48:]))
+	s.loadFactor = math.Float32frombits(le.Uint32(mapping[56:]))
+	if s.generation == 0 || s.generation > generationMask || !(s.loadFactor > 0 && s.loadFactor <= 1) {
+		return nil, fmt.Errorf("%w: wrong generation %d or load factor %v", ErrMapFileCorrupted, s.generation, s.loadFactor)
+	}
+	if s.liveItemsCount < 0 || s.deletedItemsCount < 0 ||
+		s.liveItemsCount+s.deletedItemsCount != s.allocatedItemsCount || s.allocatedItemsCount > s.capacity {
+		return nil, fmt.Errorf("%w: wrong counters", ErrMapFileCorrupted)
+	}
+	s.threshold = calcThreshold(s.capacity, s.loadFactor)
+	return s, nil
+}
+
+//fileSize returns size of the file of capacity slots, error if it doesn't fit int of the platform
+func fileSize(capacity int, itemSize int) (int, error) {
+	size := uint64(fileHeaderSize) + uint64(capacity)*uint64(itemSize)
+	if size > math.MaxInt {
+		return 0, fmt.Errorf("lhmap: file of %d slots of %d bytes is too big for the platform", capacity, itemSize)
+	}
+	return int(size), nil
+}
+
+func (s *LhMap) writeHeader(dirty bool) {
+	le := binary.LittleEndian
+	h := s.file.mapping[:fileHeaderSize]
+	copy(h[0:4], fileMagic)
+	le.PutUint32(h[4:], fileVersion)
+	le.PutUint64(h[8:], uint64(s.capacity))
+	le.PutUint32(h[16:], uint32(s.itemSize))
+	le.PutUint32(h[20:], uint32(s.keySize))
+	le.PutUint32(h[24:], uint32(s.dataSize))
+	le.PutUint16(h[28:], uint16(s.generation))
+	d := uint16(0)
+	if dirty {
+		d = 1
+	}
+	le.PutUint16(h[30:], d)
+	le.PutUint64(h[32:], uint64(s.liveItemsCount))
+	le.PutUint64(h[40:], uint64(s.allocatedItemsCount))
+	le.PutUint64(h[48:], uint64(s.deletedItemsCount))
+	le.PutUint32(h[56:], math.Float32bits(s.loadFactor))
+}
+
+//recount counts live and deleted slots
+func (s *LhMap) recount() {
+	s.liveItemsCount, s.deletedItemsCount = 0, 0
+	for i := 0; i < s.capacity; i++ {
+		if s.isLiveSlot(i) {
+			s.liveItemsCount++
+		} else if s.isDeletedSlot(i) {
+			s.deletedItemsCount++
+		}
+	}
+	s.allocatedItemsCount = s.liveItemsCount + s.deletedItemsCount
+}
+
+//mmap maps the file of size bytes, file is resized if it is opened for write
+func (mf *mapFile) mmap(size int) error {
+	if !mf.readOnly {
+		if err := mf.file.Truncate(int64(size)); err != nil {
+			return err
+		}
+	}
+	mapping, err := mmapFile(mf.file, size, mf.readOnly)
+	if err != nil {
+		return err
+	}
+	mf.mapping = mapping
+	return nil
+}
+
+//newTable creates file of the new table and maps it, slots of the new file are zeroed
+func (mf *mapFile) newTable(capacity int, itemSize int) ([]byte, error) {
+	size, err := fileSize(capacity, itemSize)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(mf.path+rehashSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(size)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	mapping, err := mmapFile(f, size, false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	mf.next, mf.nextMapping = f, mapping
+	return mapping[fileHeaderSize:], nil
+}
+
+//commitTable writes header of the new table, syncs and renames its file to the path of the map,
+//previous file is unmapped and closed
+func (mf *mapFile) commitTable(s *LhMap) error {
+	prev, prevMapping := mf.file, mf.mapping
+	mf.file, mf.mapping = mf.next, mf.nextMapping
+	mf.next, mf.nextMapping = nil, nil
+
+	s.writeHeader(true)
+	if err := mf.sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(mf.path+rehashSuffix, mf.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(mf.path)); err != nil {
+		return err
+	}
+	return errors.Join(munmapFile(prevMapping), prev.Close())
+}
+
+//sync flushes the mapping and the file to the disk, msync is required for MAP_SHARED mapping by POSIX
+func (mf *mapFile) sync() error {
+	if err := msyncFile(mf.mapping); err != nil {
+		return err
+	}
+	return mf.file.Sync()
+}
+
+//syncDir makes rename of the file durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	return errors.Join(err, d.Close())
+}
+
+func (s *LhMap) checkWritable() {
+	if s.file != nil && s.file.readOnly {
+		panic("map is read-only")
+	}
+}
+
+//Sync writes header and flushes slots of the map to the file, it does nothing if map is in memory
+func (s *LhMap) Sync() error {
+	if s.file == nil || s.file.readOnly {
+		return nil
+	}
+	s.writeHeader(false)
+	err := s.file.sync()
+	s.writeHeader(true)
+	return err
+}
+
+//Close writes header, unmaps and closes the file, map can't be used anymore. It does nothing if map is in memory
+func (s *LhMap) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	var errs []error
+	if !s.file.readOnly {
+		s.writeHeader(false)
+		errs = append(errs, s.file.sync())
+	}
+	errs = append(errs, munmapFile(s.file.mapping), s.file.file.Close())
+	s.file = nil
+	s.data = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package lhmap
+
+import (
+	"os"
+)
+
+func mmapFile(f *os.File, size int, readOnly bool) ([]byte, error) {
+	return nil, ErrMmapNotSupported
+}
+
+func munmapFile(mapping []byte) error {
+	return ErrMmapNotSupported
+}
+
+func msyncFile(mapping []byte) error {
+	return ErrMmapNotSupported
+}
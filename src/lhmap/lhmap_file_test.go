@@ -0,0 +1,249 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package lhmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func openTstLhMapFile(t *testing.T, path string) *LhMap {
+	m, err := OpenLhMapFile(path, func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("can't open map file: %v", err))
+	}
+	return m
+}
+
+//map grows in the file and is reopened with the same content
+func TestLhMapFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map")
+	m := openTstLhMapFile(t, path)
+	for i := 0; i < 10000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	for i := 0; i < 1000; i++ {
+		m.Del(tstKey(i))
+	}
+	capacity := m.Cap()
+	if err := m.Close(); err != nil {
+		t.Fatal(fmt.Sprintf("can't close map: %v", err))
+	}
+	if _, err := os.Stat(path + rehashSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Error(fmt.Sprintf("file of the new table must be renamed by rehash, actual: %v", err))
+	}
+
+	m = openTstLhMapFile(t, path)
+	if m.Len() != 9000 || m.Cap() != capacity {
+		t.Error(fmt.Sprintf("len 9000 and capacity %v expected, actual: %v, %v", capacity, m.Len(), m.Cap()))
+	}
+	checkKeys(t, m, 1000, 10000)
+	if m.Get(tstKey(0), &tstStructA{}) {
+		t.Error("deleted key must be absent")
+	}
+	for i := 10000; i < 20000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(fmt.Sprintf("can't close map: %v", err))
+	}
+
+	m, err := OpenLhMapFileReadOnly(path, func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size())
+	if err != nil {
+		t.Fatal(fmt.Sprintf("can't open map file: %v", err))
+	}
+	if m.Len() != 19000 {
+		t.Error(fmt.Sprintf("len 19000 expected, actual: %v", m.Len()))
+	}
+	checkKeys(t, m, 1000, 20000)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Put of read-only map must panic")
+			}
+		}()
+		m.Put(tstKey(0), &tstStructA{})
+	}()
+	if err := m.Close(); err != nil {
+		t.Error(fmt.Sprintf("can't close map: %v", err))
+	}
+}
+
+//counters of the map which was not closed are recounted on open
+func TestLhMapFileNotClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map")
+	m := openTstLhMapFile(t, path)
+	for i := 0; i < 1000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatal(fmt.Sprintf("can't sync map: %v", err))
+	}
+	for i := 1000; i < 1100; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	m.Del(tstKey(0))
+	//process is stopped, mapped pages are kept by the system
+	munmapFile(m.file.mapping)
+	m.file.file.Close()
+
+	m = openTstLhMapFile(t, path)
+	if m.Len() != 1099 || m.deletedItemsCount != 1 {
+		t.Error(fmt.Sprintf("len 1099 and 1 deleted slot expected, actual: %v, %v", m.Len(), m.deletedItemsCount))
+	}
+	checkKeys(t, m, 1, 1100)
+	m.Close()
+
+	//Clear is not lost, keys of the previous generation are not live again
+	path = filepath.Join(t.TempDir(), "map")
+	m = openTstLhMapFile(t, path)
+	for i := 0; i < 100; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatal(fmt.Sprintf("can't sync map: %v", err))
+	}
+	m.Clear()
+	for i := 1000; i < 1010; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	munmapFile(m.file.mapping)
+	m.file.file.Close()
+
+	m = openTstLhMapFile(t, path)
+	if m.Len() != 10 || m.Get(tstKey(5), &tstStructA{}) {
+		t.Error(fmt.Sprintf("only keys put after Clear expected, actual len: %v", m.Len()))
+	}
+	checkKeys(t, m, 1000, 1010)
+	m.Close()
+}
+
+//table of rehash interrupted by crash is dropped, the map keeps the previous table
+func TestLhMapFileRehashNotFinished(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map")
+	m := openTstLhMapFile(t, path)
+	for i := 0; i < 100; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	m.Close()
+	os.WriteFile(path+rehashSuffix, []byte("LHMF partially written table"), 0644)
+
+	m = openTstLhMapFile(t, path)
+	if m.Len() != 100 {
+		t.Error(fmt.Sprintf("len 100 expected, actual: %v", m.Len()))
+	}
+	checkKeys(t, m, 0, 100)
+	if _, err := os.Stat(path + rehashSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Error(fmt.Sprintf("file of not finished rehash must be removed, actual: %v", err))
+	}
+	for i := 100; i < 1000; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	m.Close()
+
+	m = openTstLhMapFile(t, path)
+	checkKeys(t, m, 0, 1000)
+	m.Close()
+}
+
+func TestLhMapFileClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map")
+	m := openTstLhMapFile(t, path)
+	m.Put(tstKey(1), &tstStructA{x: 1})
+	m.generation = generationMask //next Clear wraps around
+	m.Put(tstKey(2), &tstStructA{x: 2})
+	m.Clear()
+	if m.Len() != 0 || m.Get(tstKey(1), &tstStructA{}) || m.Get(tstKey(2), &tstStructA{}) {
+		t.Error("map must be empty after Clear")
+	}
+	m.Put(tstKey(3), &tstStructA{x: 3})
+	m.Close()
+
+	m = openTstLhMapFile(t, path)
+	if m.Len() != 1 || m.Get(tstKey(1), &tstStructA{}) {
+		t.Error(fmt.Sprintf("only key 3 expected, actual len: %v", m.Len()))
+	}
+	checkKeys(t, m, 3, 4)
+	m.Close()
+}
+
+func TestLhMapFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "map")
+	m := openTstLhMapFile(t, path)
+	m.Put(tstKey(1), &tstStructA{x: 1})
+	m.Close()
+
+	_, err := OpenLhMapFile(path, func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size()+4, 10)
+	if !errors.Is(err, ErrMapFileMismatch) {
+		t.Error(fmt.Sprintf("mismatch of value size expected, actual: %v", err))
+	}
+
+	data, _ := os.ReadFile(path)
+	data[0] = 'X'
+	broken := filepath.Join(dir, "broken")
+	os.WriteFile(broken, data, 0644)
+	if _, err := OpenLhMapFile(broken, func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10); !errors.Is(err, ErrMapFileCorrupted) {
+		t.Error(fmt.Sprintf("wrong magic expected, actual: %v", err))
+	}
+
+	//item size is multiple of 4, so size of 2^30 slots wraps around to 0 in int on 32-bit platforms
+	hugePath := filepath.Join(dir, "huge")
+	dataSize := 8 - ((&tstKeyA{}).Size()+int(unsafe.Sizeof(flagType(0))))%4
+	m, err = OpenLhMapFile(hugePath, func() KeyType { return &tstKeyA{} }, dataSize, 10)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("can't open map file: %v", err))
+	}
+	m.Close()
+	huge, _ := os.ReadFile(hugePath)
+	binary.LittleEndian.PutUint64(huge[8:], 1<<30)
+	os.WriteFile(hugePath, huge, 0644)
+	if _, err := OpenLhMapFile(hugePath, func() KeyType { return &tstKeyA{} }, dataSize, 10); !errors.Is(err, ErrMapFileCorrupted) {
+		t.Error(fmt.Sprintf("capacity which doesn't fit file expected, actual: %v", err))
+	}
+
+	os.WriteFile(broken, data[:fileHeaderSize/2], 0644)
+	if _, err := OpenLhMapFile(broken, func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10); !errors.Is(err, ErrMapFileCorrupted) {
+		t.Error(fmt.Sprintf("wrong size expected, actual: %v", err))
+	}
+
+	if _, err := OpenLhMapFileReadOnly(filepath.Join(dir, "absent"), func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size()); !errors.Is(err, os.ErrNotExist) {
+		t.Error(fmt.Sprintf("read-only map must not be created, actual: %v", err))
+	}
+}
+
+func TestTypedLhMapFile(t *testing.T) {
+	type point struct {
+		x, y int32
+	}
+	path := filepath.Join(t.TempDir(), "map")
+	m, err := OpenTypedLhMapFile[point, uint64](path, 10)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("can't open map file: %v", err))
+	}
+	for i := 0; i < 1000; i++ {
+		m.Put(point{int32(i), -int32(i)}, uint64(i))
+	}
+	m.Close()
+
+	m, err = OpenTypedLhMapFileReadOnly[point, uint64](path)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("can't open map file: %v", err))
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Get(point{int32(i), -int32(i)}); !ok || v != uint64(i) {
+			t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v]", i, v))
+		}
+	}
+	m.Close()
+
+	if _, err := OpenTypedLhMapFile[point, uint32](path, 10); !errors.Is(err, ErrMapFileMismatch) {
+		t.Error(fmt.Sprintf("mismatch of value size expected, actual: %v", err))
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package lhmap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func mmapFile(f *os.File, size int, readOnly bool) ([]byte, error) {
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	if readOnly {
+		prot = syscall.PROT_READ
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, prot, syscall.MAP_SHARED)
+}
+
+func munmapFile(mapping []byte) error {
+	return syscall.Munmap(mapping)
+}
+
+//msyncFile writes dirty pages of the mapping to the file, fsync alone doesn't flush MAP_SHARED pages on every platform
+func msyncFile(mapping []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(unsafe.SliceData(mapping))), uintptr(len(mapping)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package lhmap
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestUpsertAndReturnPointer(t *testing.T) {
+	m := NewLhMap(func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10)
+	counts := make(map[tstKeyA]int32)
+	for i := 0; i < 1000; i++ {
+		k := tstKeyA{a: uint32(i % 37), b: uint32(i % 11)}
+		p, isNew := m.UpsertAndReturnPointer(&k)
+		if _, exists := counts[k]; exists == isNew {
+			t.Error(fmt.Sprintf("isNew must be %v for key: %v", !exists, k))
+		}
+		if isNew && *(*tstStructA)(p) != (tstStructA{}) {
+			t.Error(fmt.Sprintf("value of new key must be zeroed: %v", *(*tstStructA)(p)))
+		}
+		(*tstStructA)(p).x++
+		counts[k]++
+	}
+
+	if m.Len() != len(counts) {
+		t.Error(fmt.Sprintf("Invalid len actual:%v but expectd %v", m.Len(), len(counts)))
+	}
+	b := tstStructA{}
+	for k, count := range counts {
+		if !m.Get(&k, &b) || b.x != count {
+			t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v], expected: [%v]", k, b.x, count))
+		}
+		if p := m.GetPointer(&k); p == nil || (*tstStructA)(p).x != count {
+			t.Error(fmt.Sprintf("pointer to the value of key %v expected", k))
+		}
+	}
+
+	//slots of deleted keys and of previous generation keep old values, they are zeroed for new keys
+	k := tstKeyA{a: 1, b: 1}
+	m.Del(&k)
+	if m.GetPointer(&k) != nil {
+		t.Error(fmt.Sprintf("map must't contains key: %v", k))
+	}
+	m.Compute(&k, func(p unsafe.Pointer, existed bool) {
+		if existed || *(*tstStructA)(p) != (tstStructA{}) {
+			t.Error(fmt.Sprintf("deleted key must be new and zeroed: %v, %v", existed, *(*tstStructA)(p)))
+		}
+	})
+	m.Clear()
+	m.Compute(&k, func(p unsafe.Pointer, existed bool) {
+		if existed || *(*tstStructA)(p) != (tstStructA{}) {
+			t.Error(fmt.Sprintf("key after Clear must be new and zeroed: %v, %v", existed, *(*tstStructA)(p)))
+		}
+	})
+}
+
+func TestTypedLhMapCompute(t *testing.T) {
+	m := NewTypedLhMap[uint64, int64](10)
+	for i := 0; i < 10000; i++ {
+		m.Compute(uint64(i%100), func(v *int64, existed bool) {
+			if existed != (i >= 100) {
+				t.Error(fmt.Sprintf("key %v must exist: %v", i%100, i >= 100))
+			}
+			*v += int64(i)
+		})
+	}
+	for k := uint64(0); k < 100; k++ {
+		expected := int64(0)
+		for i := k; i < 10000; i += 100 {
+			expected += int64(i)
+		}
+		if v, _ := m.Get(k); v != expected || *m.GetPointer(k) != expected {
+			t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v], expected: [%v]", k, v, expected))
+		}
+	}
+	if m.GetPointer(100) != nil {
+		t.Error("map must't contains key: 100")
+	}
+	if p, isNew := m.UpsertAndReturnPointer(100); !isNew || *p != 0 {
+		t.Error("key 100 must be new")
+	}
+}
+
+func TestConcurrentLhMapCompute(t *testing.T) {
+	m := NewConcurrentLhMap(func() KeyType { return &tstKeyA{} }, emptyTstStructA.Size(), 10, 4)
+	threadCount := runtime.NumCPU() + 2
+	wg := sync.WaitGroup{}
+	for th := 0; th < threadCount; th++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				k := tstKeyA{a: uint32(i % 100)}
+				m.Compute(&k, func(p unsafe.Pointer, existed bool) {
+					(*tstStructA)(p).t++
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	b := tstStructA{}
+	for i := 0; i < 100; i++ {
+		k := tstKeyA{a: uint32(i)}
+		if !m.Get(&k, &b) || b.t != int64(threadCount*100) {
+			t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v], expected: [%v]", k, b.t, threadCount*100))
+		}
+	}
+}
+
+//update of present key doesn't rehash the table, even if the next insert of absent key will do it
+func TestPointerKeptByUpdate(t *testing.T) {
+	m := newTstLhMap(10)
+	for i := 0; m.allocatedItemsCount < m.threshold; i++ {
+		m.Put(tstKey(i), &tstStructA{x: int32(i)})
+	}
+	capacity := m.Cap()
+	p := m.GetPointer(tstKey(0))
+	m.Put(tstKey(1), &tstStructA{x: 100})
+	(*tstStructA)(p).x = -1
+	if m.Cap() != capacity {
+		t.Error(fmt.Sprintf("update must not grow the table, capacity %v, actual: %v", capacity, m.Cap()))
+	}
+	checkKeys(t, m, 2, m.Len())
+	b := tstStructA{}
+	if !m.Get(tstKey(0), &b) || b.x != -1 {
+		t.Error(fmt.Sprintf("value written by pointer must be kept, actual: %v", b.x))
+	}
+	if !m.Get(tstKey(1), &b) || b.x != 100 {
+		t.Error(fmt.Sprintf("updated value expected, actual: %v", b.x))
+	}
+
+	//deleted slots over the rate rehash the table in place on insert of absent key only
+	for i := 2; m.deletedItemsCount <= m.capacity/tombstonesRate; i++ {
+		m.Del(tstKey(i))
+	}
+	p = m.GetPointer(tstKey(0))
+	m.Put(tstKey(1), &tstStructA{x: 101})
+	(*tstStructA)(p).x = -2
+	if !m.Get(tstKey(0), &b) || b.x != -2 {
+		t.Error(fmt.Sprintf("value written by pointer must be kept, actual: %v", b.x))
+	}
+	m.Put(tstKey(-1), &tstStructA{x: -1})
+	if m.deletedItemsCount != 0 {
+		t.Error(fmt.Sprintf("insert of absent key must drop deleted slots, actual: %v", m.deletedItemsCount))
+	}
+}
@@ -0,0 +1,302 @@
+package lhmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"reflect"
+	"unsafe"
+)
+
+/*
+   TypedLhMap stores keys and values of plain types (numbers, bool, arrays and structs of them) inline in the
+   arena of LhMap, without hand-written KeyType and MapValue. Key and value are copied by their memory
+   layout (unsafe.Sizeof), so types which contain pointers (pointer, string, slice, map, chan, func, interface)
+   are rejected by constructor: arena is []byte, garbage collector doesn't see pointers stored there.
+   Key is hashed by its bytes, padding of structs is skipped, so equal keys always have equal hash codes.
+   Float keys are rejected: +0 and -0 are equal but have different bytes, NaN is not equal to itself.
+   Hash code doesn't depend on the process, arena of the map may be stored and loaded.
+*/
+
+const (
+	//multiplier of the key words, from xxhash
+	keyHashPrime uint64 = 0x9E3779B185EBCA87
+	keyHashSeed  uint64 = 0x27D4EB2F165667C5
+)
+
+//byteRange is bytes of the key without padding
+type byteRange struct {
+	offset uintptr
+	size   uintptr
+}
+
+//typeLayout describes memory of the key or value type
+type typeLayout struct {
+	size   int
+	align  int
+	ranges []byteRange //bytes of the key which are hashed
+}
+
+//plainLayout checks what type has no pointers and returns its layout, isKey rejects floats
+func plainLayout(t reflect.Type, isKey bool) typeLayout {
+	l := typeLayout{size: int(t.Size()), align: t.Align()}
+	if err := collectRanges(t, 0, isKey, &l.ranges); err != nil {
+		panic(fmt.Sprintf("type %v can't be stored in LhMap: %v", t, err))
+	}
+	return l
+}
+
+func collectRanges(t reflect.Type, offset uintptr, isKey bool, ranges *[]byteRange) error {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		addRange(ranges, offset, t.Size())
+	case reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		if isKey {
+			return fmt.Errorf("float key %v, +0 and -0 are equal but have different bytes", t)
+		}
+		addRange(ranges, offset, t.Size())
+	case reflect.Array:
+		for i := 0; i < t.Len(); i++ {
+			if err := collectRanges(t.Elem(), offset+uintptr(i)*t.Elem().Size(), isKey, ranges); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if err := collectRanges(f.Type, offset+f.Offset, isKey, ranges); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%v contains pointers", t)
+	}
+	return nil
+}
+
+//addRange adds bytes to the ranges, adjacent ranges are merged, so key without padding is single range
+func addRange(ranges *[]byteRange, offset uintptr, size uintptr) {
+	if size == 0 {
+		return
+	}
+	if n := len(*ranges); n > 0 && (*ranges)[n-1].offset+(*ranges)[n-1].size == offset {
+		(*ranges)[n-1].size += size
+		return
+	}
+	*ranges = append(*ranges, byteRange{offset: offset, size: size})
+}
+
+//hashRanges hashes bytes of the key by words, result is mixed by fmix64 of MurmurHash3
+func hashRanges(p unsafe.Pointer, ranges []byteRange) uint64 {
+	h := keyHashSeed
+	for _, r := range ranges {
+		b := unsafe.Slice((*byte)(unsafe.Add(p, r.offset)), r.size)
+		for ; len(b) >= 8; b = b[8:] {
+			h = bits.RotateLeft64(h^binary.LittleEndian.Uint64(b)*keyHashPrime, 31) * keyHashPrime
+		}
+		for _, c := range b {
+			h = (h ^ uint64(c)) * keyHashPrime
+		}
+	}
+	return mix64(h)
+}
+
+//mix64 is fmix64 of MurmurHash3, every bit of k changes about half of bits of the result
+func mix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+func alignUp(size int, align int) int {
+	return (size + align - 1) / align * align
+}
+
+//typedKey is KeyType of TypedLhMap, key bytes are followed by padding which aligns flag and value
+type typedKey[K comparable] struct {
+	key    K
+	size   int
+	ranges []byteRange
+}
+
+func (k *typedKey[K]) Size() int {
+	return k.size
+}
+
+func (k *typedKey[K]) ReadFrom(p unsafe.Pointer) {
+	k.key = *(*K)(p)
+}
+
+func (k *typedKey[K]) WriteTo(p unsafe.Pointer) {
+	*(*K)(p) = k.key
+}
+
+func (k *typedKey[K]) Hash() int {
+	return int(hashRanges(unsafe.Pointer(&k.key), k.ranges))
+}
+
+func (k *typedKey[K]) Equals(p unsafe.Pointer) bool {
+	return k.key == *(*K)(p)
+}
+
+//typedValue is MapValue of TypedLhMap
+type typedValue[V any] struct {
+	value V
+	size  int
+}
+
+func (v *typedValue[V]) Size() int {
+	return v.size
+}
+
+func (v *typedValue[V]) ReadFrom(p unsafe.Pointer) {
+	v.value = *(*V)(p)
+}
+
+func (v *typedValue[V]) WriteTo(p unsafe.Pointer) {
+	*(*V)(p) = v.value
+}
+
+//TypedLhMap is LhMap of plain key and value types, see above. Like LhMap it is not thread safe,
+//even Get uses buffers of the map
+type TypedLhMap[K comparable, V any] struct {
+	m     *LhMap
+	key   typedKey[K]
+	value typedValue[V]
+}
+
+//NewTypedLhMap creates map, panics if K or V contains pointers or K contains floats
+func NewTypedLhMap[K comparable, V any](capacity int) *TypedLhMap[K, V] {
+	s, _ := newTypedLhMap[K, V](func(keyCtr func() KeyType, dataSize int) (*LhMap, error) {
+		return NewLhMap(keyCtr, dataSize, capacity), nil
+	})
+	return s
+}
+
+//OpenTypedLhMapFile opens map stored in the file or creates new one, see OpenLhMapFile
+func OpenTypedLhMapFile[K comparable, V any](path string, capacity int) (*TypedLhMap[K, V], error) {
+	return newTypedLhMap[K, V](func(keyCtr func() KeyType, dataSize int) (*LhMap, error) {
+		return OpenLhMapFile(path, keyCtr, dataSize, capacity)
+	})
+}
+
+//OpenTypedLhMapFileReadOnly opens map stored in the file for read, see OpenLhMapFileReadOnly
+func OpenTypedLhMapFileReadOnly[K comparable, V any](path string) (*TypedLhMap[K, V], error) {
+	return newTypedLhMap[K, V](func(keyCtr func() KeyType, dataSize int) (*LhMap, error) {
+		return OpenLhMapFileReadOnly(path, keyCtr, dataSize)
+	})
+}
+
+//newTypedLhMap computes sizes of the key and value in the arena, open creates LhMap of them
+func newTypedLhMap[K comparable, V any](open func(keyCtr func() KeyType, dataSize int) (*LhMap, error)) (*TypedLhMap[K, V], error) {
+	kl := plainLayout(reflect.TypeOf((*K)(nil)).Elem(), true)
+	vl := plainLayout(reflect.TypeOf((*V)(nil)).Elem(), false)
+
+	//item is key, flag, value: key and value are aligned in the arena, if item size is aligned too
+	itemAlign := kl.align
+	if vl.align > itemAlign {
+		itemAlign = vl.align
+	}
+	flagSize := int(unsafe.Sizeof(flagType(0)))
+	if flagSize > itemAlign {
+		itemAlign = flagSize
+	}
+	keySize := alignUp(kl.size+flagSize, itemAlign) - flagSize
+	dataSize := alignUp(vl.size, itemAlign)
+
+	m, err := open(func() KeyType {
+		return &typedKey[K]{size: keySize, ranges: kl.ranges}
+	}, dataSize)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedLhMap[K, V]{
+		m:     m,
+		key:   typedKey[K]{size: keySize, ranges: kl.ranges},
+		value: typedValue[V]{size: dataSize},
+	}, nil
+}
+
+func (s *TypedLhMap[K, V]) Put(key K, value V) {
+	s.key.key = key
+	s.value.value = value
+	s.m.Put(&s.key, &s.value)
+}
+
+func (s *TypedLhMap[K, V]) Get(key K) (V, bool) {
+	s.key.key = key
+	if !s.m.Get(&s.key, &s.value) {
+		var absent V
+		return absent, false
+	}
+	return s.value.value, true
+}
+
+//UpsertAndReturnPointer returns pointer to the value of the key and true if key is new, value of new key is zeroed.
+//Pointer is valid until the table is rehashed, see LhMap.UpsertAndReturnPointer
+func (s *TypedLhMap[K, V]) UpsertAndReturnPointer(key K) (*V, bool) {
+	s.key.key = key
+	p, isNew := s.m.UpsertAndReturnPointer(&s.key)
+	return (*V)(p), isNew
+}
+
+//GetPointer returns pointer to the value of the key or nil if key is absent
+func (s *TypedLhMap[K, V]) GetPointer(key K) *V {
+	s.key.key = key
+	return (*V)(s.m.GetPointer(&s.key))
+}
+
+//Compute calls fn with pointer to the value of the key, key is inserted with zero value if it is absent
+func (s *TypedLhMap[K, V]) Compute(key K, fn func(value *V, existed bool)) {
+	p, isNew := s.UpsertAndReturnPointer(key)
+	fn(p, !isNew)
+}
+
+func (s *TypedLhMap[K, V]) Del(key K) bool {
+	s.key.key = key
+	return s.m.Del(&s.key)
+}
+
+func (s *TypedLhMap[K, V]) Len() int {
+	return s.m.Len()
+}
+
+func (s *TypedLhMap[K, V]) Clear() {
+	s.m.Clear()
+}
+
+//Compact drops deleted slots, see LhMap.Compact
+func (s *TypedLhMap[K, V]) Compact() {
+	s.m.Compact()
+}
+
+//ShrinkToFit drops deleted slots and decreases capacity, see LhMap.ShrinkToFit
+func (s *TypedLhMap[K, V]) ShrinkToFit() {
+	s.m.ShrinkToFit()
+}
+
+//Sync flushes the map to the file, see LhMap.Sync
+func (s *TypedLhMap[K, V]) Sync() error {
+	return s.m.Sync()
+}
+
+//Close closes the file of the map, see LhMap.Close
+func (s *TypedLhMap[K, V]) Close() error {
+	return s.m.Close()
+}
+
+//Range calls fn for every live entry until fn returns false
+func (s *TypedLhMap[K, V]) Range(fn func(key K, value V) bool) {
+	for i := 0; i < s.m.capacity; i++ {
+		if !s.m.isLiveSlot(i) {
+			continue
+		}
+		if !fn(*(*K)(s.m.pKey(i)), *(*V)(s.m.pData(i))) {
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package lhmap
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type (
+	//key with padding after b
+	tstTypedKey struct {
+		a uint32
+		b uint8
+		c uint64
+	}
+
+	tstTypedData struct {
+		key   tstTypedKey
+		value tstStructA
+	}
+)
+
+func TestTypedLhMapPutGetDelete(t *testing.T) {
+	data := make([]tstTypedData, 0)
+	for i := 0; i < 1000; i++ {
+		data = append(data, tstTypedData{
+			key: tstTypedKey{a: rand.Uint32(), b: uint8(i), c: uint64(i)},
+			value: tstStructA{
+				t:   time.Now().Unix(),
+				x:   rand.Int31(),
+				f32: rand.Float32(),
+				f64: rand.Float64(),
+			},
+		})
+	}
+
+	m := NewTypedLhMap[tstTypedKey, tstStructA](10)
+	for i := range data {
+		m.Put(data[i].key, data[i].value)
+	}
+	if m.Len() != len(data) {
+		t.Error(fmt.Sprintf("Invalid len actual:%v but expectd %v", m.Len(), len(data)))
+	}
+
+	for i := range data {
+		k := data[i].key
+		if i%2 == 0 {
+			if !m.Del(k) {
+				t.Error(fmt.Sprintf("map must contains key: %v, i: %v", k, i))
+			}
+		} else {
+			m.Put(k, data[len(data)-1-i].value)
+		}
+	}
+
+	for i := range data {
+		k := data[i].key
+		v, found := m.Get(k)
+		if i%2 == 0 {
+			if found {
+				t.Error(fmt.Sprintf("map must't contains key: %v, i: %v", k, i))
+			}
+		} else if !found || v != data[len(data)-1-i].value {
+			t.Error(fmt.Sprintf("map must contains data for key: %v, actual: [%v], expected: [%v]", k, v, data[len(data)-1-i].value))
+		}
+	}
+
+	count := 0
+	m.Range(func(key tstTypedKey, value tstStructA) bool {
+		count++
+		return true
+	})
+	if count != len(data)/2 || m.Len() != len(data)/2 {
+		t.Error(fmt.Sprintf("Invalid len actual:%v, visited %v but expectd %v", m.Len(), count, len(data)/2))
+	}
+
+	m.Clear()
+	if _, found := m.Get(data[1].key); found || m.Len() != 0 {
+		t.Error("map must be empty after Clear")
+	}
+}
+
+//padding of the key doesn't change its hash code
+func TestTypedLhMapKeyPadding(t *testing.T) {
+	m := NewTypedLhMap[tstTypedKey, int64](10)
+	k := tstTypedKey{a: 1, b: 2, c: 3}
+	m.Put(k, 7)
+
+	dirty := k
+	padding := unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(&dirty), unsafe.Offsetof(k.b)+1)), unsafe.Offsetof(k.c)-unsafe.Offsetof(k.b)-1)
+	for i := range padding {
+		padding[i] = 0xFF
+	}
+	if v, found := m.Get(dirty); !found || v != 7 {
+		t.Error(fmt.Sprintf("key with other padding must be found, actual: %v", v))
+	}
+	if len(m.key.ranges) != 2 {
+		t.Error(fmt.Sprintf("padding must be skipped, ranges: %v", m.key.ranges))
+	}
+}
+
+//key and value are aligned in the arena
+func TestTypedLhMapAlignment(t *testing.T) {
+	m := NewTypedLhMap[uint8, int64](10)
+	for i := 0; i < 200; i++ {
+		m.Put(uint8(i), int64(i))
+	}
+	//int64 is aligned to 4 on 32-bit platforms
+	align := int(unsafe.Alignof(int64(0)))
+	if m.m.itemSize%align != 0 || m.m.headerSize%align != 0 {
+		t.Error(fmt.Sprintf("item %v and header %v must be aligned to %v", m.m.itemSize, m.m.headerSize, align))
+	}
+	for i := 0; i < m.m.capacity; i++ {
+		if uintptr(m.m.pData(i))%uintptr(align) != 0 {
+			t.Error(fmt.Sprintf("value of slot %v is not aligned", i))
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if v, found := m.Get(uint8(i)); !found || v != int64(i) {
+			t.Error(fmt.Sprintf("map must contains key: %v, actual: %v", i, v))
+		}
+	}
+}
+
+func expectPanic(t *testing.T, name string, fn func()) {
+	defer func() {
+		if recover() == nil {
+			t.Error(fmt.Sprintf("%v must be rejected", name))
+		}
+	}()
+	fn()
+}
+
+func TestTypedLhMapRejectsPointers(t *testing.T) {
+	expectPanic(t, "pointer key", func() { NewTypedLhMap[*int, int](10) })
+	expectPanic(t, "string key", func() { NewTypedLhMap[string, int](10) })
+	expectPanic(t, "interface key", func() { NewTypedLhMap[any, int](10) })
+	expectPanic(t, "float key", func() { NewTypedLhMap[float64, int](10) })
+	expectPanic(t, "struct key with float", func() { NewTypedLhMap[struct{ a int; f float32 }, int](10) })
+	expectPanic(t, "slice value", func() { NewTypedLhMap[int, []int](10) })
+	expectPanic(t, "map value", func() { NewTypedLhMap[int, map[int]int](10) })
+	expectPanic(t, "array of strings value", func() { NewTypedLhMap[int, [2]string](10) })
+	expectPanic(t, "struct value with pointer", func() { NewTypedLhMap[int, struct{ p *int }](10) })
+
+	//floats are allowed in values
+	m := NewTypedLhMap[[2]uint16, struct{ f float64; ok bool }](10)
+	m.Put([2]uint16{1, 2}, struct{ f float64; ok bool }{1.5, true})
+	if v, found := m.Get([2]uint16{1, 2}); !found || v.f != 1.5 || !v.ok {
+		t.Error(fmt.Sprintf("map must contains value, actual: %v", v))
+	}
+}